@@ -0,0 +1,131 @@
+package d64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An AllocationStrategy decides in which order tracks are searched for free sectors when adding files.
+// Implementations never return the DirTrack, storage on the DirTrack is handled by the Disk.
+type AllocationStrategy interface {
+	// FirstTracks returns the tracks in the order they are searched for the first sector of a new file.
+	FirstTracks() []byte
+	// NextTracks returns the tracks in the order they are searched for the next sector of a file,
+	// when its previous sector is located on track current.
+	// The current track is included first, unless it is the DirTrack.
+	NextTracks(current byte) []byte
+}
+
+// Available allocation strategies.
+var (
+	// LinearAllocation fills the disk linearly from track 1 to MaxTracks, files only continue on higher tracks.
+	LinearAllocation AllocationStrategy = linearAllocation{}
+	// DOSAllocation mimics the 1541 DOS: it starts next to the DirTrack and alternates outwards, minimizing head movement.
+	DOSAllocation AllocationStrategy = dosAllocation{}
+	// OuterAllocation fills the tracks furthest away from the DirTrack first, keeping the tracks around the directory free.
+	OuterAllocation AllocationStrategy = outerAllocation{}
+)
+
+// allocation returns the AllocationStrategy of the disk.
+func (d *Disk) allocation() AllocationStrategy {
+	if d.Allocation == nil {
+		return LinearAllocation
+	}
+	return d.Allocation
+}
+
+// tracksUp returns the tracks from first up to last inclusive, skipping the DirTrack.
+func tracksUp(first, last int) (tracks []byte) {
+	tracks = make([]byte, 0, MaxTracks)
+	for track := first; track <= last; track++ {
+		if track >= 1 && track <= MaxTracks && track != DirTrack {
+			tracks = append(tracks, byte(track))
+		}
+	}
+	return tracks
+}
+
+// tracksDown returns the tracks from first down to last inclusive, skipping the DirTrack.
+func tracksDown(first, last int) (tracks []byte) {
+	tracks = make([]byte, 0, MaxTracks)
+	for track := first; track >= last; track-- {
+		if track >= 1 && track <= MaxTracks && track != DirTrack {
+			tracks = append(tracks, byte(track))
+		}
+	}
+	return tracks
+}
+
+type linearAllocation struct{}
+
+func (linearAllocation) FirstTracks() []byte {
+	return tracksUp(1, MaxTracks)
+}
+
+func (linearAllocation) NextTracks(current byte) []byte {
+	return tracksUp(int(current), MaxTracks)
+}
+
+type dosAllocation struct{}
+
+func (dosAllocation) FirstTracks() (tracks []byte) {
+	for distance := 1; distance < MaxTracks; distance++ {
+		tracks = append(tracks, tracksUp(DirTrack-distance, DirTrack-distance)...)
+		tracks = append(tracks, tracksUp(DirTrack+distance, DirTrack+distance)...)
+	}
+	return tracks
+}
+
+func (a dosAllocation) NextTracks(current byte) (tracks []byte) {
+	switch {
+	case current < DirTrack:
+		tracks = append(tracksDown(int(current), 1), tracksUp(DirTrack+1, MaxTracks)...)
+		return append(tracks, tracksDown(DirTrack-1, int(current)+1)...)
+	case current > DirTrack:
+		tracks = append(tracksUp(int(current), MaxTracks), tracksDown(DirTrack-1, 1)...)
+		return append(tracks, tracksUp(DirTrack+1, int(current)-1)...)
+	}
+	return a.FirstTracks()
+}
+
+type outerAllocation struct{}
+
+func (outerAllocation) FirstTracks() (tracks []byte) {
+	for distance := MaxTracks - 1; distance > 0; distance-- {
+		tracks = append(tracks, tracksUp(DirTrack-distance, DirTrack-distance)...)
+		tracks = append(tracks, tracksUp(DirTrack+distance, DirTrack+distance)...)
+	}
+	return tracks
+}
+
+func (a outerAllocation) NextTracks(current byte) (tracks []byte) {
+	switch {
+	case current < DirTrack:
+		tracks = append(tracksUp(int(current), DirTrack-1), tracksDown(MaxTracks, DirTrack+1)...)
+		return append(tracks, tracksDown(int(current)-1, 1)...)
+	case current > DirTrack:
+		tracks = append(tracksDown(int(current), DirTrack+1), tracksUp(1, DirTrack-1)...)
+		return append(tracks, tracksUp(int(current)+1, MaxTracks)...)
+	}
+	return a.FirstTracks()
+}
+
+// Names of the available allocation strategies, as used by AllocationByName.
+const (
+	AllocationLinear = "linear"
+	AllocationDOS    = "dos"
+	AllocationOuter  = "outer"
+)
+
+// AllocationByName returns the AllocationStrategy named name, an empty name returns LinearAllocation.
+func AllocationByName(name string) (AllocationStrategy, error) {
+	switch strings.ToLower(name) {
+	case "", AllocationLinear:
+		return LinearAllocation, nil
+	case AllocationDOS:
+		return DOSAllocation, nil
+	case AllocationOuter:
+		return OuterAllocation, nil
+	}
+	return nil, fmt.Errorf("unknown allocation strategy %q", name)
+}
@@ -0,0 +1,126 @@
+package d64
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestAllocationStrategies(t *testing.T) {
+	strategies := []struct {
+		name       string
+		strategy   AllocationStrategy
+		firstTrack []byte
+		// upward strategies only continue files on higher tracks
+		upward bool
+	}{
+		{"linear", LinearAllocation, []byte{1, 2}, true},
+		{"dos", DOSAllocation, []byte{17, 19}, false},
+		{"outer", OuterAllocation, []byte{1, 35}, false},
+	}
+	for _, c := range strategies {
+		for current := byte(1); current <= MaxTracks; current++ {
+			if current == DirTrack {
+				continue
+			}
+			next := c.strategy.NextTracks(current)
+			want := MaxTracks - 1
+			if c.upward {
+				want = len(tracksUp(int(current), MaxTracks))
+			}
+			if len(next) != want {
+				t.Errorf("%s NextTracks(%d) returned %d tracks, want %d", c.name, current, len(next), want)
+			}
+			if next[0] != current {
+				t.Errorf("%s NextTracks(%d) starts at track %d", c.name, current, next[0])
+			}
+			seen := map[byte]bool{}
+			for _, track := range next {
+				if track == DirTrack || seen[track] {
+					t.Errorf("%s NextTracks(%d) contains track %d illegally", c.name, current, track)
+				}
+				seen[track] = true
+			}
+		}
+		if len(c.strategy.FirstTracks()) != MaxTracks-1 {
+			t.Errorf("%s FirstTracks returned %d tracks, want %d", c.name, len(c.strategy.FirstTracks()), MaxTracks-1)
+		}
+
+		d := NewDisk(c.name, "01 2a", DefaultSectorInterleave)
+		d.Allocation = c.strategy
+		prg := bytes.Repeat([]byte{0xea}, 30*BlockSize)
+		for n := 0; n < 2; n++ {
+			if err := d.AddPrg("file "+strconv.Itoa(n), prg); err != nil {
+				t.Fatalf("%s d.AddPrg failed: %v", c.name, err)
+			}
+		}
+		for n, e := range d.Directory() {
+			got, err := d.Extract(e.Track, e.Sector)
+			if err != nil {
+				t.Fatalf("%s d.Extract(%d, %d) failed: %v", c.name, e.Track, e.Sector, err)
+			}
+			if !bytes.Equal(got, prg) {
+				t.Errorf("%s d.Extract(%d, %d) content mismatch", c.name, e.Track, e.Sector)
+			}
+			if n < len(c.firstTrack) && e.Track != c.firstTrack[n] {
+				t.Errorf("%s file %d starts on track %d, want %d", c.name, n, e.Track, c.firstTrack[n])
+			}
+		}
+	}
+}
+
+func TestDOSAllocation(t *testing.T) {
+	want := []byte{17, 19, 16, 20, 15, 21}
+	if got := DOSAllocation.FirstTracks()[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("DOSAllocation.FirstTracks got %v want %v", got, want)
+	}
+	want = []byte{16, 15, 14}
+	if got := DOSAllocation.NextTracks(16)[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("DOSAllocation.NextTracks(16) got %v want %v", got, want)
+	}
+	want = []byte{1, 19, 20}
+	if got := DOSAllocation.NextTracks(1)[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("DOSAllocation.NextTracks(1) got %v want %v", got, want)
+	}
+	want = []byte{1, 35, 2, 34}
+	if got := OuterAllocation.FirstTracks()[:len(want)]; !bytes.Equal(got, want) {
+		t.Errorf("OuterAllocation.FirstTracks got %v want %v", got, want)
+	}
+}
+
+func TestPinnedPlacement(t *testing.T) {
+	d := NewDisk("pinned", "01 2a", DefaultSectorInterleave)
+	prg := bytes.Repeat([]byte{0xea}, 25*BlockSize)
+	if err := d.AddPrgWithOptions("pinned", prg, AddOptions{Track: 20, Sector: 5}); err != nil {
+		t.Fatalf("d.AddPrgWithOptions pinned failed: %v", err)
+	}
+	if e := d.Directory()[0]; e.Track != 20 || e.Sector != 5 {
+		t.Errorf("pinned file starts on track %d sector %d, want 20, 5", e.Track, e.Sector)
+	}
+	if err := d.AddPrgWithOptions("occupied", prg, AddOptions{Track: 20, Sector: 5}); err == nil {
+		t.Errorf("d.AddPrgWithOptions on allocated track 20 sector 5 should have failed")
+	}
+	if err := d.AddPrgWithOptions("dirtrack", prg, AddOptions{Track: DirTrack, Sector: 10}); err == nil {
+		t.Errorf("d.AddPrgWithOptions on the DirTrack should have failed")
+	}
+	if err := d.AddPrgWithOptions("illegal", prg, AddOptions{Track: 31, Sector: 17}); err == nil {
+		t.Errorf("d.AddPrgWithOptions on illegal track 31 sector 17 should have failed")
+	}
+
+	if err := d.AddPrgWithOptions("ranged", prg, AddOptions{FirstTrack: 30, LastTrack: 32}); err != nil {
+		t.Fatalf("d.AddPrgWithOptions ranged failed: %v", err)
+	}
+	e := d.Directory()[1]
+	chain, err := d.chain(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.chain(%d, %d) failed: %v", e.Track, e.Sector, err)
+	}
+	for _, ts := range chain {
+		if ts.Track < 30 || ts.Track > 32 {
+			t.Errorf("ranged file uses track %d outside of range 30-32", ts.Track)
+		}
+	}
+	if err := d.AddPrgWithOptions("toolarge", prg, AddOptions{FirstTrack: 35, LastTrack: 35}); err == nil {
+		t.Errorf("d.AddPrgWithOptions %d blocks on track 35 should have failed", SizeToBlocks(len(prg)))
+	}
+}
@@ -0,0 +1,112 @@
+package d64
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Byte offsets used for the canonical form of the BAM sector and directory entries.
+const (
+	bamPaddingOffset      = 0xab
+	entryUnusedOffset     = 19
+	entryUnusedOffsetEnd  = 28
+	dirEntrySize          = 32
+	dirEntryPayloadLength = 30
+)
+
+// clearUnusedEntryBytes zerofills the REL and GEOS bytes of the PRG directory entry at offset i of s.
+func clearUnusedEntryBytes(s *Sector, i int) {
+	for j := i + entryUnusedOffset; j < i+entryUnusedOffsetEnd; j++ {
+		s.Data[j] = 0
+	}
+}
+
+// Canonicalize zerofills all data that is not in use, making the image deterministic:
+// unreferenced free sectors, empty directory slots, unused bytes of PRG directory entries
+// and the remainder of the last sector of each file.
+// Sectors referenced by the directory or any file are kept, even if the BAM marks them free.
+// Allocated sectors that are not part of any file, like raw sectors, and the BAM bytes from
+// bamPaddingOffset, used by extended formats, are left untouched.
+func (d *Disk) Canonicalize() {
+	used := d.referencedSectors()
+	for track := byte(1); track <= MaxTracks; track++ {
+		for sector := byte(0); sector < totalSectors(track); sector++ {
+			if !d.bam[track-1][sector] && !used[track-1][sector] {
+				d.sector(track, sector).zero()
+			}
+		}
+	}
+	d.sector(DirTrack, 0).Data[3] = 0
+
+	track, sector := byte(DirTrack), byte(1)
+	for n := byte(0); n < totalSectors(DirTrack); n++ {
+		s := d.sector(track, sector)
+		for i := 2; i < SectorSize; i += dirEntrySize {
+			if i > 2 {
+				s.Data[i-2], s.Data[i-1] = 0, 0
+			}
+			switch {
+			case s.Data[i] == 0:
+				for j := i; j < i+dirEntryPayloadLength; j++ {
+					s.Data[j] = 0
+				}
+			case s.Data[i]&FileIDMask == PrgFileID:
+				clearUnusedEntryBytes(s, i)
+			}
+		}
+		if s.TrackLink() == 0 {
+			s.SetSectorLink(0xff)
+			break
+		}
+		track, sector = s.TrackLink(), s.SectorLink()
+		if sectorIsValid(track, sector) != nil {
+			break
+		}
+	}
+
+	for _, e := range d.Directory() {
+		chain, _ := d.chain(e.Track, e.Sector)
+		if len(chain) == 0 {
+			continue
+		}
+		last := chain[len(chain)-1]
+		s := d.sector(last.Track, last.Sector)
+		if s.TrackLink() != 0 || s.SectorLink() < 1 {
+			continue
+		}
+		for i := int(s.SectorLink()) + 1; i < SectorSize; i++ {
+			s.Data[i] = 0
+		}
+	}
+}
+
+// referencedSectors returns the sectors in the directory chain, in the (partial) chain of each file
+// and those allocated by WriteRaw. A broken chain ends at the broken link, which references nothing.
+func (d *Disk) referencedSectors() (used [MaxTracks][MaxSectors]bool) {
+	used = d.raw
+	dirEntries := append(d.Directory(), DirEntry{Track: DirTrack, Sector: 0})
+	for _, e := range dirEntries {
+		chain, _ := d.chain(e.Track, e.Sector)
+		for _, ts := range chain {
+			used[ts.Track-1][ts.Sector] = true
+		}
+	}
+	return used
+}
+
+// CanonicalBytes returns the .d64 image in canonical form, see Canonicalize. The disk itself is not modified.
+func (d *Disk) CanonicalBytes() []byte {
+	c := d.Clone()
+	c.Canonicalize()
+	buf := bytes.NewBuffer(make([]byte, 0, ImageSize))
+	_, _ = c.WriteTo(buf)
+	return buf.Bytes()
+}
+
+// CanonicalHash returns the hex encoded SHA-256 hash of the CanonicalBytes of the disk.
+// Disks with equal content and layout return the same hash, regardless of leftover data.
+func (d *Disk) CanonicalHash() string {
+	sum := sha256.Sum256(d.CanonicalBytes())
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,125 @@
+package d64
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func TestCanonicalNewDisk(t *testing.T) {
+	d := NewDisk("d.addfile", "votox", DefaultSectorInterleave)
+	for n, filename := range testPrgs {
+		if err := d.AddFile(filename, "file "+strconv.Itoa(n)); err != nil {
+			t.Fatalf("d.AddFile %q failed: %v", filename, err)
+		}
+	}
+	want, err := ioutil.ReadFile(validatedD64)
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile %q failed: %v", validatedD64, err)
+	}
+	if !bytes.Equal(d.CanonicalBytes(), want) {
+		t.Errorf("d.CanonicalBytes of new disk does not match %q", validatedD64)
+	}
+}
+
+func TestCanonicalGarbage(t *testing.T) {
+	clean := NewDisk("garbage", "01 2a", DefaultSectorInterleave)
+	dirty := NewDisk("garbage", "01 2a", DefaultSectorInterleave)
+	for track := byte(1); track <= MaxTracks; track++ {
+		for sector := byte(0); sector < totalSectors(track); sector++ {
+			if !dirty.bam[track-1][sector] {
+				for i := range dirty.Tracks[track-1].Sectors[sector].Data {
+					dirty.Tracks[track-1].Sectors[sector].Data[i] = byte(track + sector + byte(i))
+				}
+			}
+		}
+	}
+	if clean.CanonicalHash() != dirty.CanonicalHash() {
+		t.Errorf("CanonicalHash of empty disks with garbage differs")
+	}
+
+	for _, d := range []*Disk{clean, dirty} {
+		for n := 0; n < 10; n++ {
+			if err := d.AddFile(testPrg1, "file "+strconv.Itoa(n)); err != nil {
+				t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+			}
+		}
+	}
+	if clean.CanonicalHash() != dirty.CanonicalHash() {
+		t.Errorf("CanonicalHash of disks with garbage differs after adding files")
+	}
+	cleanBytes := &bytes.Buffer{}
+	if _, err := clean.WriteTo(cleanBytes); err != nil {
+		t.Fatalf("clean.WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(dirty.CanonicalBytes(), cleanBytes.Bytes()) {
+		t.Errorf("dirty.CanonicalBytes does not match the clean disk")
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	hash := d.CanonicalHash()
+	var prgs [][]byte
+	for _, e := range d.Directory() {
+		prg, err := d.Extract(e.Track, e.Sector)
+		if err != nil {
+			t.Fatalf("d.Extract(%d, %d) failed: %v", e.Track, e.Sector, err)
+		}
+		prgs = append(prgs, prg)
+	}
+
+	d.Canonicalize()
+	if got := d.CanonicalHash(); got != hash {
+		t.Errorf("CanonicalHash changed after Canonicalize: got %s want %s", got, hash)
+	}
+	for n, e := range d.Directory() {
+		prg, err := d.Extract(e.Track, e.Sector)
+		if err != nil {
+			t.Fatalf("d.Extract(%d, %d) failed: %v", e.Track, e.Sector, err)
+		}
+		if !bytes.Equal(prg, prgs[n]) {
+			t.Errorf("file %q changed after Canonicalize", e.Filename)
+		}
+	}
+	if d.Label != testD64Label || d.DiskID != testD64DiskID {
+		t.Errorf("label or id changed after Canonicalize")
+	}
+}
+
+func TestCanonicalizeKeepsReferenced(t *testing.T) {
+	d := NewDisk("keep", "01 2a", DefaultSectorInterleave)
+	if err := d.AddFile(testPrg1, "one"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	e := d.Directory()[0]
+	want, err := d.Extract(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.Extract failed: %v", err)
+	}
+	for i := bamPaddingOffset; i < SectorSize; i++ {
+		d.Tracks[DirTrack-1].Sectors[0].Data[i] = 0x55
+	}
+	chain, err := d.chain(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.chain failed: %v", err)
+	}
+	for _, ts := range chain {
+		d.bam[ts.Track-1][ts.Sector] = false
+	}
+
+	d.Canonicalize()
+	got, err := d.Extract(e.Track, e.Sector)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("file %q referenced by the directory but free in the BAM was zerofilled", e.Filename)
+	}
+	for i := bamPaddingOffset; i < SectorSize; i++ {
+		if d.Tracks[DirTrack-1].Sectors[0].Data[i] != 0x55 {
+			t.Fatalf("BAM byte %#x was cleared", i)
+		}
+	}
+}
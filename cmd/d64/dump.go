@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/staD020/d64"
+)
+
+// dumpPerLine is the amount of bytes per line in a hex dump.
+const dumpPerLine = 16
+
+// parseTrackSector parses "track,sector".
+func parseTrackSector(s string) (track, sector byte, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected track,sector, got %q", s)
+	}
+	if track, err = parseByte(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("illegal track %q: %v", parts[0], err)
+	}
+	if sector, err = parseByte(parts[1]); err != nil {
+		return 0, 0, fmt.Errorf("illegal sector %q: %v", parts[1], err)
+	}
+	return track, sector, nil
+}
+
+// petscii returns a printable representation of PETSCII byte b, '.' for control codes and graphics.
+func petscii(b byte) byte {
+	switch {
+	case b >= 0x20 && b <= 0x5a:
+		return b
+	case b == 0x5b || b == 0x5d:
+		return b
+	case b == 0xa0:
+		return ' '
+	case b >= 0xc1 && b <= 0xda:
+		return b - 0xc1 + 'a'
+	}
+	return '.'
+}
+
+// hexDump writes a hex+PETSCII dump of data to w.
+func hexDump(w io.Writer, data []byte) error {
+	for offset := 0; offset < len(data); offset += dumpPerLine {
+		line := data[offset:]
+		if len(line) > dumpPerLine {
+			line = line[:dumpPerLine]
+		}
+		text := make([]byte, len(line))
+		for i, b := range line {
+			text[i] = petscii(b)
+		}
+		hexed := strings.TrimSpace(fmt.Sprintf("% x", line))
+		if _, err := fmt.Fprintf(w, "%02x: %-47s  %s\n", offset, hexed, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpSector prints a hex+PETSCII dump of track,sector in the .d64 in args.
+func dumpSector(trackSector string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	track, sector, err := parseTrackSector(trackSector)
+	if err != nil {
+		return err
+	}
+	d, err := d64.LoadDisk(args[0])
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", args[0], err)
+	}
+	data, err := d.ReadSector(track, sector)
+	if err != nil {
+		return fmt.Errorf("d.ReadSector failed: %v", err)
+	}
+	fmt.Printf("track %d sector %d\n", track, sector)
+	return hexDump(os.Stdout, data)
+}
+
+// pokeSector patches bytes in the .d64 in args, poke is formatted as track,sector,offset=hexbytes.
+func pokeSector(poke string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	i := strings.IndexByte(poke, '=')
+	if i < 0 {
+		return fmt.Errorf("expected track,sector,offset=hexbytes, got %q", poke)
+	}
+	parts := strings.Split(poke[:i], ",")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected track,sector,offset=hexbytes, got %q", poke)
+	}
+	track, sector, err := parseTrackSector(parts[0] + "," + parts[1])
+	if err != nil {
+		return err
+	}
+	offset, err := parseByte(parts[2])
+	if err != nil {
+		return fmt.Errorf("illegal offset %q: %v", parts[2], err)
+	}
+	patch, err := hex.DecodeString(strings.NewReplacer(" ", "", ":", "").Replace(poke[i+1:]))
+	if err != nil {
+		return fmt.Errorf("illegal hex bytes %q: %v", poke[i+1:], err)
+	}
+	if int(offset)+len(patch) > d64.SectorSize {
+		return fmt.Errorf("%d bytes at offset %d do not fit in a sector", len(patch), offset)
+	}
+
+	path := args[0]
+	d, err := d64.LoadDisk(path)
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", path, err)
+	}
+	data, err := d.ReadSector(track, sector)
+	if err != nil {
+		return fmt.Errorf("d.ReadSector failed: %v", err)
+	}
+	copy(data[offset:], patch)
+	if err = d.WriteSector(track, sector, data); err != nil {
+		return fmt.Errorf("d.WriteSector failed: %v", err)
+	}
+	if err = d.WriteFileWithBackups(path, flagBackups); err != nil {
+		return fmt.Errorf("d.WriteFileWithBackups %q failed: %v", path, err)
+	}
+	if !flagQuiet {
+		fmt.Printf("poked %d bytes at track %d sector %d offset %d\n", len(patch), track, sector, offset)
+	}
+	if flagVerbose {
+		return hexDump(os.Stdout, data)
+	}
+	return nil
+}
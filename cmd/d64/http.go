@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/staD020/d64"
+)
+
+// An imageServer serves a web UI and JSON API over the .d64 images in a directory.
+//
+// The API is:
+//
+//	GET  /api/images                    list all images
+//	GET  /api/images/NAME               directory of image NAME
+//	GET  /api/images/NAME/bam           BAM map, + is used and - is free
+//	GET  /api/images/NAME/image         download the image
+//	GET  /api/images/NAME/files/FILE    download prg FILE
+//	POST /api/images/NAME/files         add the multipart prg field, optionally named by the name field
+//
+// Errors are replied as {"error": "message"}. Uploads from browser pages of another origin are forbidden.
+type imageServer struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// An imageInfo describes an image in the list of images.
+type imageInfo struct {
+	Name       string `json:"name"`
+	Label      string `json:"label"`
+	ID         string `json:"id"`
+	BlocksFree int    `json:"blocks_free"`
+	Files      int    `json:"files"`
+	Error      string `json:"error,omitempty"`
+}
+
+// A fileInfo describes a file in the directory of an image.
+type fileInfo struct {
+	Filename     string `json:"filename"`
+	Blocks       int    `json:"blocks"`
+	Track        byte   `json:"track"`
+	Sector       byte   `json:"sector"`
+	StartAddress uint16 `json:"start_address"`
+}
+
+// A directoryInfo is the directory of an image, Listing is the human readable directory.
+type directoryInfo struct {
+	imageInfo
+	Directory []fileInfo `json:"directory"`
+	Listing   string     `json:"listing"`
+}
+
+// A bamTrack is a single track of the BAM map.
+type bamTrack struct {
+	Track   byte   `json:"track"`
+	Free    int    `json:"free"`
+	Sectors string `json:"sectors"`
+}
+
+// httpD64 serves the images in the -dir of args over http.
+func httpD64(args []string) error {
+	fs := flag.NewFlagSet("http", flag.ContinueOnError)
+	dir := fs.String("dir", ".", "directory with .d64 images")
+	listen := fs.String("listen", "localhost:8064", "tcp address to serve http on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	if !flagQuiet {
+		fmt.Printf("serving images in %q on http://%s/\n", *dir, *listen)
+	}
+	srv := &imageServer{dir: *dir}
+	return http.ListenAndServe(*listen, srv.handler())
+}
+
+// handler returns the http.Handler for the web UI and API.
+func (srv *imageServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", srv.index)
+	mux.HandleFunc("/view/", srv.view)
+	mux.HandleFunc("/api/images", srv.apiImages)
+	mux.HandleFunc("/api/images/", srv.apiImage)
+	return mux
+}
+
+// httpError is an error with the http status code to reply.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// errorCode returns the http status code for err.
+func errorCode(err error) int {
+	var he *httpError
+	switch {
+	case errors.As(err, &he):
+		return he.code
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, d64.ErrFileNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, d64.ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, d64.ErrDiskFull), errors.Is(err, d64.ErrDirectoryFull):
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes v as JSON with status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// writeError writes err as JSON error.
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, errorCode(err), map[string]string{"error": err.Error()})
+}
+
+// path returns the path of image name, which must be a .d64 in srv.dir.
+func (srv *imageServer) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || !strings.EqualFold(filepath.Ext(name), ".d64") {
+		return "", &httpError{http.StatusNotFound, fmt.Errorf("image %q not found", name)}
+	}
+	return filepath.Join(srv.dir, name), nil
+}
+
+// load loads image name.
+func (srv *imageServer) load(name string) (*d64.Disk, error) {
+	path, err := srv.path(name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := d64.LoadDisk(path)
+	if err != nil {
+		return nil, fmt.Errorf("d64.LoadDisk %q failed: %w", name, err)
+	}
+	return d, nil
+}
+
+// images returns the info of all images in srv.dir, sorted by name.
+func (srv *imageServer) images() ([]imageInfo, error) {
+	entries, err := os.ReadDir(srv.dir)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir %q failed: %w", srv.dir, err)
+	}
+	images := []imageInfo{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.EqualFold(filepath.Ext(e.Name()), ".d64") {
+			continue
+		}
+		info := imageInfo{Name: e.Name()}
+		if d, err := srv.load(e.Name()); err != nil {
+			info.Error = err.Error()
+		} else {
+			info = newImageInfo(e.Name(), d)
+		}
+		images = append(images, info)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].Name < images[j].Name })
+	return images, nil
+}
+
+func newImageInfo(name string, d *d64.Disk) imageInfo {
+	return imageInfo{
+		Name:       name,
+		Label:      d.Label,
+		ID:         d.DiskID,
+		BlocksFree: d.BlocksFree(),
+		Files:      len(d.Directory()),
+	}
+}
+
+// directory returns the directory of image name.
+func (srv *imageServer) directory(name string) (*directoryInfo, error) {
+	d, err := srv.load(name)
+	if err != nil {
+		return nil, err
+	}
+	dir := &directoryInfo{imageInfo: newImageInfo(name, d), Directory: []fileInfo{}, Listing: d.String()}
+	for _, e := range d.Directory() {
+		dir.Directory = append(dir.Directory, fileInfo{
+			Filename:     e.Filename,
+			Blocks:       e.BlockSize,
+			Track:        e.Track,
+			Sector:       e.Sector,
+			StartAddress: d.StartAddress(e),
+		})
+	}
+	return dir, nil
+}
+
+// bam returns the BAM map of image name, parsed from the output of PrintBAMTo.
+func (srv *imageServer) bam(name string) ([]bamTrack, error) {
+	d, err := srv.load(name)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	if _, err = d.PrintBAMTo(buf); err != nil {
+		return nil, fmt.Errorf("d.PrintBAMTo failed: %w", err)
+	}
+	var tracks []bamTrack
+	sc := bufio.NewScanner(buf)
+	for sc.Scan() {
+		sectors := sc.Text()
+		if i := strings.Index(sectors, ": "); i >= 0 {
+			sectors = sectors[i+2:]
+		}
+		tracks = append(tracks, bamTrack{
+			Track:   byte(len(tracks) + 1),
+			Free:    strings.Count(sectors, "-"),
+			Sectors: sectors,
+		})
+	}
+	return tracks, nil
+}
+
+// file returns the prg of filename on image name.
+func (srv *imageServer) file(name, filename string) ([]byte, error) {
+	d, err := srv.load(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range d.Directory() {
+		if e.Filename == filename {
+			prg, err := d.Extract(e.Track, e.Sector)
+			if err != nil {
+				return nil, fmt.Errorf("d.Extract %q failed: %w", filename, err)
+			}
+			return prg, nil
+		}
+	}
+	return nil, fmt.Errorf("file %q: %w", filename, d64.ErrFileNotFound)
+}
+
+// addPrg adds prg as filename to image name and writes the image.
+func (srv *imageServer) addPrg(name, filename string, prg []byte) error {
+	d, err := srv.load(name)
+	if err != nil {
+		return err
+	}
+	for _, e := range d.Directory() {
+		if e.Filename == filename {
+			return fmt.Errorf("file %q: %w", filename, d64.ErrFileExists)
+		}
+	}
+	if err = d.AddPrg(filename, prg); err != nil {
+		return fmt.Errorf("d.AddPrg %q failed: %w", filename, err)
+	}
+	path, _ := srv.path(name)
+	if err = d.WriteFileWithBackups(path, flagBackups); err != nil {
+		return fmt.Errorf("d.WriteFileWithBackups %q failed: %w", name, err)
+	}
+	return nil
+}
+
+// sameOrigin returns an error if r was sent by a browser from another origin, e.g. a page posting to localhost.
+// Requests without Sec-Fetch-Site and Origin headers do not come from a browser and are allowed.
+func sameOrigin(r *http.Request) error {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		if site != "same-origin" && site != "none" {
+			return &httpError{http.StatusForbidden, fmt.Errorf("cross-site request (Sec-Fetch-Site %s) not allowed", site)}
+		}
+		return nil
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return &httpError{http.StatusForbidden, fmt.Errorf("cross-origin request from %q not allowed", origin)}
+	}
+	return nil
+}
+
+// upload adds the prg of a multipart form to image name, returning the filename on the image.
+// Cross-origin uploads are rejected, see sameOrigin.
+func (srv *imageServer) upload(w http.ResponseWriter, r *http.Request, name string) (string, error) {
+	if err := sameOrigin(r); err != nil {
+		return "", err
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, d64.ImageSize)
+	f, header, err := r.FormFile("prg")
+	if err != nil {
+		return "", &httpError{http.StatusBadRequest, fmt.Errorf("r.FormFile prg failed: %v", err)}
+	}
+	defer f.Close()
+	prg, err := io.ReadAll(f)
+	if err != nil {
+		return "", &httpError{http.StatusBadRequest, fmt.Errorf("reading prg failed: %v", err)}
+	}
+	filename := r.FormValue("name")
+	if filename == "" {
+		filename = header.Filename
+		if ext := filepath.Ext(filename); strings.EqualFold(ext, ".prg") {
+			filename = strings.TrimSuffix(filename, ext)
+		}
+	}
+	filename = d64.NormalizeFilename(filename)
+	if filename == "" {
+		return "", &httpError{http.StatusBadRequest, errors.New("empty filename")}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return filename, srv.addPrg(name, filename, prg)
+}
+
+// apiImages handles /api/images.
+func (srv *imageServer) apiImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)})
+		return
+	}
+	srv.mu.Lock()
+	images, err := srv.images()
+	srv.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, images)
+}
+
+// apiImage handles /api/images/NAME and its subresources.
+func (srv *imageServer) apiImage(w http.ResponseWriter, r *http.Request) {
+	name, sub := strings.TrimPrefix(r.URL.Path, "/api/images/"), ""
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name, sub = name[:i], name[i+1:]
+	}
+	if r.Method == http.MethodPost && sub == "files" {
+		filename, err := srv.upload(w, r, name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{"filename": filename})
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, &httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)})
+		return
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	switch {
+	case sub == "":
+		dir, err := srv.directory(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, dir)
+	case sub == "bam":
+		tracks, err := srv.bam(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, tracks)
+	case sub == "image":
+		d, err := srv.load(name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAttachment(w, name, "application/octet-stream")
+		d.WriteTo(w)
+	case strings.HasPrefix(sub, "files/"):
+		filename := strings.TrimPrefix(sub, "files/")
+		prg, err := srv.file(name, filename)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeAttachment(w, filename+".prg", "application/octet-stream")
+		w.Write(prg)
+	default:
+		writeError(w, &httpError{http.StatusNotFound, fmt.Errorf("%q not found", r.URL.Path)})
+	}
+}
+
+// writeAttachment sets the headers to download the response as filename.
+func writeAttachment(w http.ResponseWriter, filename, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+var (
+	indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>d64 images</title></head>
+<body>
+<h1>d64 images</h1>
+<table>
+<tr><th>image</th><th>label</th><th>id</th><th>files</th><th>blocks free</th></tr>
+{{range .}}<tr><td><a href="/view/{{.Name}}">{{.Name}}</a></td>{{if .Error}}<td colspan="4">{{.Error}}</td>{{else}}<td>{{.Label}}</td><td>{{.ID}}</td><td>{{.Files}}</td><td>{{.BlocksFree}}</td>{{end}}</tr>
+{{end}}</table>
+</body></html>
+`))
+	viewTemplate = template.Must(template.New("view").Funcs(template.FuncMap{"pathEscape": url.PathEscape}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Dir.Name}}</title></head>
+<body>
+<p><a href="/">images</a></p>
+<h1>{{.Dir.Name}}</h1>
+<pre>{{.Dir.Listing}}</pre>
+<ul>
+{{range .Dir.Directory}}<li><a href="/api/images/{{pathEscape $.Dir.Name}}/files/{{pathEscape .Filename}}">{{.Filename}}</a> {{.Blocks}} blocks</li>
+{{end}}</ul>
+<p><a href="/api/images/{{pathEscape .Dir.Name}}/image">download image</a></p>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="prg"> <input type="text" name="name" placeholder="filename"> <input type="submit" value="add prg">
+</form>
+<h2>BAM</h2>
+<pre>{{range .BAM}}{{printf "%02d" .Track}}: {{.Sectors}}
+{{end}}</pre>
+</body></html>
+`))
+)
+
+// index handles /, listing all images.
+func (srv *imageServer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	srv.mu.Lock()
+	images, err := srv.images()
+	srv.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), errorCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	indexTemplate.Execute(w, images)
+}
+
+// view handles /view/NAME, showing the directory and BAM of an image. Posting a prg adds it to the image.
+func (srv *imageServer) view(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/view/")
+	var uploadErr error
+	if r.Method == http.MethodPost {
+		if _, uploadErr = srv.upload(w, r, name); uploadErr == nil {
+			http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+			return
+		}
+	}
+
+	srv.mu.Lock()
+	dir, err := srv.directory(name)
+	var tracks []bamTrack
+	if err == nil {
+		tracks, err = srv.bam(name)
+	}
+	srv.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), errorCode(err))
+		return
+	}
+	data := struct {
+		Dir   *directoryInfo
+		BAM   []bamTrack
+		Error error
+	}{dir, tracks, uploadErr}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if uploadErr != nil {
+		w.WriteHeader(errorCode(uploadErr))
+	}
+	viewTemplate.Execute(w, data)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/staD020/d64"
+)
+
+func newTestImageServer(t *testing.T) (*httptest.Server, string) {
+	dir := filepath.Dir(copyTestImage(t))
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not an image"), 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	srv := &imageServer{dir: dir}
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(ts.Close)
+	return ts, dir
+}
+
+func getJSON(t *testing.T, url string, wantCode int, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("http.Get %q failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantCode {
+		t.Fatalf("GET %q == %d, want %d", url, resp.StatusCode, wantCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("json.Decode %q failed: %v", url, err)
+	}
+}
+
+func TestHTTPImages(t *testing.T) {
+	ts, _ := newTestImageServer(t)
+	var images []imageInfo
+	getJSON(t, ts.URL+"/api/images", http.StatusOK, &images)
+	if len(images) != 1 || images[0].Name != "test.d64" || images[0].Files != 2 {
+		t.Fatalf("images == %+v, want test.d64 with 2 files", images)
+	}
+
+	var dir directoryInfo
+	getJSON(t, ts.URL+"/api/images/test.d64", http.StatusOK, &dir)
+	if len(dir.Directory) != 2 || dir.Listing == "" {
+		t.Fatalf("directory == %+v", dir)
+	}
+
+	var tracks []bamTrack
+	getJSON(t, ts.URL+"/api/images/test.d64/bam", http.StatusOK, &tracks)
+	if len(tracks) != d64.MaxTracks || tracks[d64.DirTrack-1].Sectors[0] != '+' {
+		t.Errorf("bam == %+v", tracks)
+	}
+
+	var e map[string]string
+	getJSON(t, ts.URL+"/api/images/missing.d64", http.StatusNotFound, &e)
+	getJSON(t, ts.URL+"/api/images/notes.txt", http.StatusNotFound, &e)
+	getJSON(t, ts.URL+"/api/images/test.d64/files/missing", http.StatusNotFound, &e)
+	if e["error"] == "" {
+		t.Errorf("missing error message")
+	}
+
+	srv := &imageServer{dir: "images"}
+	for _, name := range []string{"", "../test.d64", ".d64", "sub/test.d64", "test.prg"} {
+		if _, err := srv.path(name); err == nil {
+			t.Errorf("srv.path(%q) did not fail", name)
+		}
+	}
+}
+
+func TestHTTPDownload(t *testing.T) {
+	ts, dir := newTestImageServer(t)
+	d, err := d64.LoadDisk(filepath.Join(dir, "test.d64"))
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	e := d.Directory()[0]
+	want, err := d.Extract(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.Extract failed: %v", err)
+	}
+	resp, err := http.Get(ts.URL + "/api/images/test.d64/files/" + e.Filename)
+	if err != nil {
+		t.Fatalf("http.Get failed: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(got, want) {
+		t.Errorf("download %q == %d, %d bytes, want %d bytes", e.Filename, resp.StatusCode, len(got), len(want))
+	}
+
+	resp, err = http.Get(ts.URL + "/api/images/test.d64/image")
+	if err != nil {
+		t.Fatalf("http.Get failed: %v", err)
+	}
+	got, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(got) != d64.ImageSize {
+		t.Errorf("downloaded image is %d bytes, want %d", len(got), d64.ImageSize)
+	}
+}
+
+func postPrg(t *testing.T, url, filename string, prg []byte) *http.Response {
+	t.Helper()
+	return postPrgWithHeader(t, url, filename, prg, nil)
+}
+
+// postPrgWithHeader posts prg as a multipart form, adding the headers of header.
+func postPrgWithHeader(t *testing.T, url, filename string, prg []byte, header map[string]string) *http.Response {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("prg", filename)
+	if err != nil {
+		t.Fatalf("mw.CreateFormFile failed: %v", err)
+	}
+	fw.Write(prg)
+	mw.Close()
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatalf("http.NewRequest %q failed: %v", url, err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http.Post %q failed: %v", url, err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHTTPUpload(t *testing.T) {
+	ts, dir := newTestImageServer(t)
+	prg := []byte{0x01, 0x08, 0x60}
+	if resp := postPrg(t, ts.URL+"/api/images/test.d64/files", "Upload.prg", prg); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("upload == %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if resp := postPrg(t, ts.URL+"/api/images/test.d64/files", "upload.prg", prg); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second upload == %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	d, err := d64.LoadDisk(filepath.Join(dir, "test.d64"))
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	got, err := d.ReadFile("upload")
+	if err != nil || !bytes.Equal(got, prg) {
+		t.Errorf("uploaded prg == % x, %v, want % x", got, err, prg)
+	}
+
+	resp, err := http.Get(ts.URL + "/view/test.d64")
+	if err != nil {
+		t.Fatalf("http.Get failed: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "/api/images/test.d64/files/upload") {
+		t.Errorf("view == %d, missing link to upload", resp.StatusCode)
+	}
+}
+
+func TestHTTPUploadCrossOrigin(t *testing.T) {
+	ts, dir := newTestImageServer(t)
+	prg := []byte{0x01, 0x08, 0x60}
+	host := strings.TrimPrefix(ts.URL, "http://")
+	cases := []struct {
+		header map[string]string
+		want   int
+	}{
+		{map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": ts.URL}, http.StatusForbidden},
+		{map[string]string{"Sec-Fetch-Site": "same-site"}, http.StatusForbidden},
+		{map[string]string{"Origin": "http://" + host}, http.StatusCreated},
+		{map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusCreated},
+	}
+	for n, c := range cases {
+		name := "cross" + strconv.Itoa(n) + ".prg"
+		if resp := postPrgWithHeader(t, ts.URL+"/api/images/test.d64/files", name, prg, c.header); resp.StatusCode != c.want {
+			t.Errorf("upload with %v == %d, want %d", c.header, resp.StatusCode, c.want)
+		}
+	}
+	if resp := postPrgWithHeader(t, ts.URL+"/view/test.d64", "view.prg", prg, cases[0].header); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin upload to the view == %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	d, err := d64.LoadDisk(filepath.Join(dir, "test.d64"))
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	if got, want := len(d.Directory()), 4; got != want {
+		t.Errorf("image has %d files after the uploads, want %d", got, want)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,20 +17,39 @@ import (
 var (
 	flagAdd       string
 	flagBAM       bool
+	flagBackups   int
+	flagChain     string
 	flagDirectory string
+	flagDump      string
 	flagExtract   string
 	flagHelp      bool
+	flagLayout    string
+	flagListen    string
+	flagOut       string
+	flagPoke      string
+	flagRepro     string
+	flagSplit     string
 	flagQuiet     bool
 	flagVerbose   bool
 )
 
 func init() {
-	flag.StringVar(&flagAdd, "add", "", "add files to .d64 (-add d64.d64 file1.prg file2.prg)")
+	flag.StringVar(&flagAdd, "add", "", "add files to .d64 (-add d64.d64 file1.prg file2.prg,interleave=4,dirtrack=true,track=17,sector=0,tracks=1-17)")
 	flag.StringVar(&flagAdd, "a", "", "add")
-	flag.StringVar(&flagExtract, "extract", "", "extract .prgs from .d64 (-extract d64.d64)")
+	flag.StringVar(&flagExtract, "extract", "", "extract .prgs from .d64, optionally only those matching 1541 patterns (-extract d64.d64 \"intro*,demo?=p\")")
 	flag.StringVar(&flagExtract, "e", "", "extract")
 	flag.StringVar(&flagDirectory, "dir", "", "prints the directory from .d64 (-dir d64.d64)")
 	flag.StringVar(&flagDirectory, "d", "", "dir")
+	flag.StringVar(&flagLayout, "layout", "", "write the track/sector layout of all files as acme, kickass, 64tass or json include (-layout acme d64.d64)")
+	flag.StringVar(&flagOut, "out", "", "write output to file instead of stdout")
+	flag.StringVar(&flagOut, "o", "", "out")
+	flag.StringVar(&flagRepro, "verify-repro", "", "rebuild the manifest twice and verify the images are byte-identical to each other and its output (-verify-repro manifest.json)")
+	flag.StringVar(&flagSplit, "split", "", "split files over as many sides as needed, writing name_side1.d64 etc. and a flip list (-split name file1.prg file2.prg,side=2)")
+	flag.StringVar(&flagChain, "chain", "", "print the track/sector chain of the files matching a 1541 pattern (-chain \"intro*\" d64.d64)")
+	flag.StringVar(&flagDump, "dump", "", "print a hex and PETSCII dump of a sector (-dump 18,0 d64.d64)")
+	flag.StringVar(&flagPoke, "poke", "", "patch bytes in a sector, offset and bytes in hex (-poke 18,0,0x90=4e45574e414d45 d64.d64)")
+	flag.StringVar(&flagListen, "listen", "localhost:1541", "tcp address to serve a .d64 as virtual drive on (-listen :1541 serve d64.d64)")
+	flag.IntVar(&flagBackups, "backups", 0, "amount of previous versions to keep as .bak files when writing a .d64")
 	flag.BoolVar(&flagBAM, "bam", false, "display BAM")
 	flag.BoolVar(&flagBAM, "b", false, "bam")
 
@@ -48,21 +70,66 @@ func main() {
 	}
 
 	showUsage := true
+	if len(files) > 0 && files[0] == "build" {
+		showUsage = false
+		if err := buildManifests(files[1:]); err != nil {
+			panic(err)
+		}
+		files = nil
+	}
+
+	if len(files) > 0 && files[0] == "shell" {
+		showUsage = false
+		if err := runShell(files[1:]); err != nil {
+			panic(err)
+		}
+		files = nil
+	}
+
+	if len(files) > 0 && files[0] == "edit" {
+		showUsage = false
+		if err := editD64(files[1:]); err != nil {
+			panic(err)
+		}
+		files = nil
+	}
+
+	if len(files) > 0 && files[0] == "serve" {
+		showUsage = false
+		if err := serveD64(files[1:]); err != nil {
+			panic(err)
+		}
+		files = nil
+	}
+
+	if len(files) > 0 && files[0] == "http" {
+		showUsage = false
+		if err := httpD64(files[1:]); err != nil {
+			panic(err)
+		}
+		files = nil
+	}
+
 	if flagAdd != "" {
 		showUsage = false
 		if err := addToD64(flagAdd, files); err != nil {
-			if err = newD64(flagAdd, files); err != nil {
-				panic(err)
-			}
+			panic(err)
 		}
 		if !flagQuiet {
 			fmt.Printf("added %d files to %q\n", len(files), flagAdd)
 		}
 	}
 
+	if flagSplit != "" {
+		showUsage = false
+		if err := splitD64(flagSplit, files); err != nil {
+			panic(err)
+		}
+	}
+
 	if flagExtract != "" {
 		showUsage = false
-		if err := extractD64(flagExtract); err != nil {
+		if err := extractD64(flagExtract, files); err != nil {
 			panic(err)
 		}
 		if !flagQuiet {
@@ -77,15 +144,67 @@ func main() {
 			panic(err)
 		}
 		fmt.Println(d)
+		if flagVerbose {
+			for _, e := range d.Directory() {
+				interleave, err := d.FileInterleave(e)
+				if err != nil {
+					fmt.Printf("%-16q interleave unknown: %v\n", e.Filename, err)
+					continue
+				}
+				fmt.Printf("%-16q interleave %d\n", e.Filename, interleave)
+			}
+			fmt.Println()
+		}
 		if flagBAM {
 			if _, err = d.PrintBAMTo(os.Stdout); err != nil {
 				panic(err)
 			}
 		}
+		printWarnings(d)
+	}
+
+	if flagChain != "" {
+		showUsage = false
+		if err := printChain(flagChain, files); err != nil {
+			panic(err)
+		}
+	}
+
+	if flagDump != "" {
+		showUsage = false
+		if err := dumpSector(flagDump, files); err != nil {
+			panic(err)
+		}
+	}
+
+	if flagPoke != "" {
+		showUsage = false
+		if err := pokeSector(flagPoke, files); err != nil {
+			panic(err)
+		}
+	}
+
+	if flagRepro != "" {
+		showUsage = false
+		if err := verifyRepro(flagRepro); err != nil {
+			panic(err)
+		}
+	}
+
+	if flagLayout != "" {
+		showUsage = false
+		if err := writeLayout(flagLayout, files, flagOut); err != nil {
+			panic(err)
+		}
 	}
 
 	if showUsage || flagHelp {
-		fmt.Println("Usage: ./d64 [-v -q -h -b -a foo.d64 -d foo.d64 -e foo.d64] [FILE [FILES]]")
+		fmt.Println("Usage: ./d64 [-v -q -h -b -a foo.d64 -d foo.d64 -e foo.d64 -layout acme -o out.asm] [FILE [FILES]]")
+		fmt.Println("       ./d64 [-v -q -o foo.d64] build manifest.json [manifest.json]")
+		fmt.Println("       ./d64 [-backups 1] edit foo.d64")
+		fmt.Println("       ./d64 [-backups 1] shell foo.d64")
+		fmt.Println("       ./d64 [-v -q -backups 1 -listen localhost:1541] serve foo.d64")
+		fmt.Println("       ./d64 [-q -backups 1] http [-dir ./images -listen localhost:8064]")
 		fmt.Println()
 		flag.PrintDefaults()
 	}
@@ -97,17 +216,11 @@ func main() {
 
 func newD64(path string, prgs []string) error {
 	d := d64.NewDisk(filepath.Base(path), "01 2a", d64.DefaultSectorInterleave)
-	for _, prg := range prgs {
-		name, ext := d64.NormalizeFilename(filepath.Base(prg)), filepath.Ext(prg)
-		if strings.ToLower(ext) == ".prg" {
-			name = strings.TrimSuffix(name, ext)
-		}
-		if err := d.AddFile(prg, name); err != nil {
-			return fmt.Errorf("d.AddFile %q failed: %v", prg, err)
-		}
+	if err := addFiles(d, prgs); err != nil {
+		return err
 	}
-	if err := d.WriteFile(path); err != nil {
-		return fmt.Errorf("d.WriteFile %q failed: %v", path, err)
+	if err := d.WriteFileWithBackups(path, flagBackups); err != nil {
+		return fmt.Errorf("d.WriteFileWithBackups %q failed: %v", path, err)
 	}
 
 	if flagVerbose {
@@ -116,33 +229,147 @@ func newD64(path string, prgs []string) error {
 	return nil
 }
 
+// addToD64 adds prgs to the image at path, creating a new image only if path does not exist.
 func addToD64(path string, prgs []string) error {
 	d, err := d64.LoadDisk(path)
-	if err != nil {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return newD64(path, prgs)
+	case err != nil:
 		return fmt.Errorf("d64.LoadDisk %q failed: %v", path, err)
 	}
 
-	for _, prg := range prgs {
-		name, ext := d64.NormalizeFilename(filepath.Base(prg)), filepath.Ext(prg)
-		if strings.ToLower(ext) == ".prg" {
-			name = strings.TrimSuffix(name, ext)
-		}
-		if err := d.AddFile(prg, name); err != nil {
-			return fmt.Errorf("d.AddFile %q failed: %v", prg, err)
-		}
+	if err := addFiles(d, prgs); err != nil {
+		return err
 	}
 
 	if flagVerbose {
 		fmt.Println(d)
 	}
 
-	if err := d.WriteFile(path); err != nil {
-		return fmt.Errorf("d.WriteFile %q failed: %v", path, err)
+	if err := d.WriteFileWithBackups(path, flagBackups); err != nil {
+		return fmt.Errorf("d.WriteFileWithBackups %q failed: %v", path, err)
+	}
+	return nil
+}
+
+// addFiles adds the files in args to d, each arg is a path optionally followed by comma separated options.
+func addFiles(d *d64.Disk, args []string) error {
+	for _, arg := range args {
+		path, f, err := parseFileArg(arg)
+		if err != nil {
+			return fmt.Errorf("parseFileArg %q failed: %v", arg, err)
+		}
+		if f.Side != 0 {
+			return fmt.Errorf("option side of %q is only supported by -split", arg)
+		}
+		if err := d.AddFileWithOptions(path, f.Name, f.Options); err != nil {
+			return fmt.Errorf("d.AddFileWithOptions %q failed: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// parseFileArg splits arg in the path and its options, e.g. "file.prg,interleave=4,dirtrack=true,track=17,sector=0,tracks=1-17,side=2".
+// Comma separated parts without "=" are considered part of the path.
+// The returned File contains the filename derived from path and its options, the prg is not loaded.
+func parseFileArg(arg string) (path string, f d64.File, err error) {
+	parts := strings.Split(arg, ",")
+	n := len(parts)
+	for n > 1 && strings.Contains(parts[n-1], "=") {
+		n--
+	}
+	path = strings.Join(parts[:n], ",")
+	f.Name = d64.NormalizeFilename(filepath.Base(path))
+	if ext := filepath.Ext(path); strings.ToLower(ext) == ".prg" {
+		f.Name = strings.TrimSuffix(f.Name, strings.ToLower(ext))
+	}
+	opt := &f.Options
+	for _, part := range parts[n:] {
+		kv := strings.SplitN(part, "=", 2)
+		key, value := strings.ToLower(kv[0]), kv[1]
+		switch key {
+		case "interleave":
+			if opt.Interleave, err = parseByte(value); err != nil || opt.Interleave == 0 {
+				return path, f, fmt.Errorf("invalid interleave %q", value)
+			}
+		case "dirtrack":
+			if opt.UseDirTrack, err = strconv.ParseBool(value); err != nil {
+				return path, f, fmt.Errorf("invalid dirtrack %q: %v", value, err)
+			}
+		case "track":
+			if opt.Track, err = parseByte(value); err != nil || opt.Track == 0 {
+				return path, f, fmt.Errorf("invalid track %q", value)
+			}
+		case "sector":
+			if opt.Sector, err = parseByte(value); err != nil {
+				return path, f, fmt.Errorf("invalid sector %q", value)
+			}
+		case "tracks":
+			r := strings.SplitN(value, "-", 2)
+			if len(r) != 2 {
+				return path, f, fmt.Errorf("invalid tracks %q, want first-last", value)
+			}
+			if opt.FirstTrack, err = parseByte(r[0]); err != nil {
+				return path, f, fmt.Errorf("invalid tracks %q: %v", value, err)
+			}
+			if opt.LastTrack, err = parseByte(r[1]); err != nil {
+				return path, f, fmt.Errorf("invalid tracks %q: %v", value, err)
+			}
+		case "side":
+			if f.Side, err = strconv.Atoi(value); err != nil || f.Side < 1 {
+				return path, f, fmt.Errorf("invalid side %q", value)
+			}
+		default:
+			return path, f, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return path, f, nil
+}
+
+// parseByte parses s as decimal, or hexadecimal with 0x or $ prefix.
+func parseByte(s string) (byte, error) {
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	return byte(v), err
+}
+
+// splitD64 distributes the files in args over as many sides as needed and writes them using base.
+func splitD64(base string, args []string) error {
+	var files []d64.File
+	for _, arg := range args {
+		path, f, err := parseFileArg(arg)
+		if err != nil {
+			return fmt.Errorf("parseFileArg %q failed: %v", arg, err)
+		}
+		if f.Prg, err = os.ReadFile(path); err != nil {
+			return fmt.Errorf("os.ReadFile %q failed: %v", path, err)
+		}
+		files = append(files, f)
+	}
+	disks, err := d64.SplitSides(filepath.Base(base), d64.DefaultSectorInterleave, files)
+	if err != nil {
+		return fmt.Errorf("d64.SplitSides failed: %v", err)
+	}
+	paths, err := d64.WriteSides(base, disks)
+	if err != nil {
+		return fmt.Errorf("d64.WriteSides %q failed: %v", base, err)
+	}
+	if flagVerbose {
+		for _, d := range disks {
+			fmt.Println(d)
+		}
+	}
+	if !flagQuiet {
+		fmt.Printf("wrote %d sides: %s\n", len(disks), strings.Join(paths, ", "))
 	}
 	return nil
 }
 
-func extractD64(path string) error {
+// extractD64 extracts all files from the .d64 at path, or only those matching patterns, to the current directory.
+func extractD64(path string, patterns []string) error {
 	d, err := d64.LoadDisk(path)
 	if err != nil {
 		return fmt.Errorf("d64.LoadDisk %q failed: %v", path, err)
@@ -152,8 +379,180 @@ func extractD64(path string) error {
 		fmt.Println(d)
 	}
 
-	if _, err = d.ExtractToPath("."); err != nil {
-		return fmt.Errorf("d.ExtractToPath %q failed: %v", ".", err)
+	pattern := "*"
+	if len(patterns) > 0 {
+		pattern = strings.Join(patterns, ",")
+	}
+	paths, err := d.ExtractMatchingToPath(".", pattern)
+	if err != nil {
+		return fmt.Errorf("d.ExtractMatchingToPath %q failed: %v", pattern, err)
+	}
+	if flagVerbose {
+		for _, path := range paths {
+			fmt.Println("extracted", path)
+		}
+	}
+	printWarnings(d)
+	return nil
+}
+
+// printChain prints the sector chain of all files matching pattern in the .d64 in args.
+func printChain(pattern string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	d, err := d64.LoadDisk(args[0])
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", args[0], err)
+	}
+	found, err := d.Find(pattern)
+	if err != nil {
+		return fmt.Errorf("d.Find %q failed: %v", pattern, err)
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("pattern %q: %w", pattern, d64.ErrFileNotFound)
+	}
+	for _, e := range found {
+		chain, err := d.Chain(e)
+		bytes, jumps := 0, 0
+		fmt.Printf("%q\n", e.Filename)
+		for i, cs := range chain {
+			bytes += cs.Bytes
+			step := ""
+			if i > 0 {
+				prev := chain[i-1]
+				if prev.Track == cs.Track {
+					total := int(d.Tracks[cs.Track-1].TotalSectors())
+					step = fmt.Sprintf("+%d", (int(cs.Sector)-int(prev.Sector)+total)%total)
+				} else {
+					step = fmt.Sprintf("track %+d", int(cs.Track)-int(prev.Track))
+					jumps++
+				}
+			}
+			fmt.Println(strings.TrimRight(fmt.Sprintf("%4d  tr %2d sec %2d  %3d bytes  %s", i+1, cs.Track, cs.Sector, cs.Bytes, step), " "))
+		}
+		fmt.Printf("%d sectors, %d bytes, %d track changes\n", len(chain), bytes, jumps)
+		if err != nil {
+			fmt.Printf("broken chain: %v\n", err)
+		}
+		fmt.Println()
+	}
+	printWarnings(d)
+	return nil
+}
+
+// printWarnings prints the warnings collected on d to stderr, unless quiet.
+func printWarnings(d *d64.Disk) {
+	if flagQuiet {
+		return
+	}
+	for _, w := range d.Warnings() {
+		fmt.Fprintf(os.Stderr, "warn: %s\n", w)
+	}
+}
+
+// writeLayout writes the layout of the .d64 in args to out, or stdout if out is empty.
+func writeLayout(format string, args []string, out string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("-layout requires exactly one .d64, got %d", len(args))
+	}
+	d, err := d64.LoadDisk(args[0])
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", args[0], err)
+	}
+	if out == "" {
+		return d64.WriteLayout(os.Stdout, d, format)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("os.Create %q failed: %v", out, err)
+	}
+	defer f.Close()
+	if err = d64.WriteLayout(f, d, format); err != nil {
+		return fmt.Errorf("d64.WriteLayout %q failed: %v", out, err)
+	}
+	return nil
+}
+
+// buildManifests builds and writes the disks declared in the manifests.
+func buildManifests(manifests []string) error {
+	if len(manifests) == 0 {
+		return fmt.Errorf("build requires at least one manifest")
+	}
+	if flagOut != "" && len(manifests) > 1 {
+		return fmt.Errorf("-out can only be used with a single manifest")
+	}
+	for _, path := range manifests {
+		m, err := d64.LoadManifest(path)
+		if err != nil {
+			return fmt.Errorf("d64.LoadManifest %q failed: %v", path, err)
+		}
+		d, err := m.Build()
+		if err != nil {
+			return fmt.Errorf("m.Build %q failed: %v", path, err)
+		}
+		out := m.OutputPath()
+		if flagOut != "" {
+			out = flagOut
+		}
+		if err = d.WriteFileWithBackups(out, flagBackups); err != nil {
+			return fmt.Errorf("d.WriteFileWithBackups %q failed: %v", out, err)
+		}
+		if flagVerbose {
+			fmt.Println(d)
+		}
+		if !flagQuiet {
+			fmt.Printf("built %q from %q\n", out, path)
+		}
+	}
+	return nil
+}
+
+// verifyRepro builds the manifest twice and verifies both images are byte-identical to each other
+// and to the existing output image.
+func verifyRepro(path string) error {
+	var images [2][]byte
+	var hash string
+	for i := range images {
+		m, err := d64.LoadManifest(path)
+		if err != nil {
+			return fmt.Errorf("d64.LoadManifest %q failed: %v", path, err)
+		}
+		d, err := m.Build()
+		if err != nil {
+			return fmt.Errorf("m.Build %q failed: %v", path, err)
+		}
+		buf := &bytes.Buffer{}
+		if _, err = d.WriteTo(buf); err != nil {
+			return fmt.Errorf("d.WriteTo failed: %v", err)
+		}
+		images[i], hash = buf.Bytes(), d.CanonicalHash()
+	}
+	if !bytes.Equal(images[0], images[1]) {
+		return fmt.Errorf("builds of %q are not byte-identical", path)
+	}
+
+	m, err := d64.LoadManifest(path)
+	if err != nil {
+		return fmt.Errorf("d64.LoadManifest %q failed: %v", path, err)
+	}
+	out := m.OutputPath()
+	if flagOut != "" {
+		out = flagOut
+	}
+	existing, err := os.ReadFile(out)
+	switch {
+	case os.IsNotExist(err):
+		if !flagQuiet {
+			fmt.Printf("%q does not exist, only verified the rebuild\n", out)
+		}
+	case err != nil:
+		return fmt.Errorf("os.ReadFile %q failed: %v", out, err)
+	case !bytes.Equal(existing, images[0]):
+		return fmt.Errorf("%q is not byte-identical to the rebuild of %q", out, path)
+	}
+	if !flagQuiet {
+		fmt.Printf("reproducible build of %q verified, sha256 %s\n", path, hash)
 	}
 	return nil
 }
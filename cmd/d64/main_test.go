@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/staD020/d64"
+)
+
+const validatedD64 = "../../testdata/validated.d64"
+
+// copyTestImage copies validatedD64 to test.d64 in a new temporary directory and returns its path.
+func copyTestImage(t *testing.T) string {
+	t.Helper()
+	bin, err := os.ReadFile(validatedD64)
+	if err != nil {
+		t.Fatalf("os.ReadFile %q failed: %v", validatedD64, err)
+	}
+	path := filepath.Join(t.TempDir(), "test.d64")
+	if err = os.WriteFile(path, bin, 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestAddToD64(t *testing.T) {
+	path := copyTestImage(t)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile failed: %v", err)
+	}
+	// track 1 sector 0 is used by the first file of the image
+	if err = addToD64(path, []string{"../../testdata/testfile1.prg,track=1,sector=0"}); err == nil {
+		t.Errorf("addToD64 to an allocated sector should have failed")
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Errorf("failed addToD64 replaced %q", path)
+	}
+
+	if err = os.WriteFile(path, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	if err = addToD64(path, []string{"../../testdata/testfile1.prg"}); err == nil {
+		t.Errorf("addToD64 to an invalid image should have failed")
+	}
+
+	missing := filepath.Join(filepath.Dir(path), "new.d64")
+	if err = addToD64(missing, []string{"../../testdata/testfile1.prg"}); err != nil {
+		t.Fatalf("addToD64 to a missing image failed: %v", err)
+	}
+	d, err := d64.LoadDisk(missing)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	if n := len(d.Directory()); n != 1 {
+		t.Errorf("new image has %d files, want 1", n)
+	}
+}
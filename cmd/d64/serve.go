@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/staD020/d64"
+)
+
+// serveMaxRead is the maximum amount of bytes returned by a single READ request.
+const serveMaxRead = 4096
+
+// A driveServer serves a .d64 as a virtual drive over TCP.
+//
+// The protocol is line based, each request is answered with a single line.
+// Binary data is hex encoded. Requests are:
+//
+//	OPEN channel name     open a file, see d64.Drive.Open, replies with the status
+//	READ channel [n]      read up to n bytes, replies DATA hexbytes, or EOF at the end of the file
+//	WRITE channel hex     write bytes to a channel opened for writing or a # buffer, replies with the status
+//	CLOSE channel         close the channel, files opened for writing are saved, replies with the status
+//	CMD command           execute a DOS command on the command channel, replies with the status
+//	STATUS                read the status from the command channel
+//	BREAD track sector    read a sector, replies DATA hexbytes
+//	BWRITE track sector hex  write a full sector, replies with the status
+//	QUIT                  close the connection
+//
+// All connections share a single drive, including its channels, like devices on a serial bus.
+// Changes are written to the image after each modifying request, a failed write is replied with ERROR message.
+// A request that panics, e.g. on a corrupt image, is replied with "20,READ ERROR,00,00" and the image is not written.
+// The drive is then reloaded from the image, discarding its open channels and any partial change.
+type driveServer struct {
+	mu    sync.Mutex
+	path  string
+	drive *d64.Drive
+}
+
+// serveD64 serves the .d64 in args on flagListen until the listener fails.
+func serveD64(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	d, err := d64.LoadDisk(args[0])
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", args[0], err)
+	}
+	srv := &driveServer{path: args[0], drive: d64.NewDrive(d)}
+	l, err := net.Listen("tcp", flagListen)
+	if err != nil {
+		return fmt.Errorf("net.Listen %q failed: %v", flagListen, err)
+	}
+	defer l.Close()
+	if !flagQuiet {
+		fmt.Printf("serving %q on %s\n", srv.path, l.Addr())
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("l.Accept failed: %v", err)
+		}
+		go srv.serveConn(conn)
+	}
+}
+
+// serveConn handles the requests of a single connection.
+func (srv *driveServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if flagVerbose {
+		fmt.Printf("%s connected\n", conn.RemoteAddr())
+	}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			if strings.EqualFold(line, "QUIT") {
+				break
+			}
+			if _, werr := fmt.Fprintln(conn, srv.handle(line)); werr != nil {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if flagVerbose {
+		fmt.Printf("%s disconnected\n", conn.RemoteAddr())
+	}
+}
+
+// handle executes a single request and returns the reply, changes are saved afterwards.
+func (srv *driveServer) handle(line string) (reply string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "request %q failed: %v\n", line, r)
+			srv.reload()
+			reply = d64.Status{Code: d64.StatusReadError}.String()
+		}
+	}()
+	reply = srv.request(line)
+	if err := srv.save(); err != nil {
+		return fmt.Sprintf("ERROR %v", err)
+	}
+	return reply
+}
+
+// reload replaces the drive by a new drive on the image, keeping the current drive if loading fails.
+func (srv *driveServer) reload() {
+	d, err := d64.LoadDisk(srv.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reloading %q failed: %v\n", srv.path, err)
+		return
+	}
+	srv.drive = d64.NewDrive(d)
+}
+
+func (srv *driveServer) request(line string) string {
+	verb, args := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		verb, args = line[:i], line[i+1:]
+	}
+	dr := srv.drive
+	syntaxError := d64.Status{Code: d64.StatusSyntaxError}.String()
+	switch strings.ToUpper(verb) {
+	case "OPEN":
+		fields := strings.SplitN(args, " ", 2)
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return syntaxError
+		}
+		name := ""
+		if len(fields) == 2 {
+			name = fields[1]
+		}
+		return dr.Open(channel, name).String()
+	case "READ":
+		fields := strings.Fields(args)
+		if len(fields) < 1 || len(fields) > 2 {
+			return syntaxError
+		}
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return syntaxError
+		}
+		n := serveMaxRead
+		if len(fields) == 2 {
+			if n, err = strconv.Atoi(fields[1]); err != nil || n < 1 || n > serveMaxRead {
+				return syntaxError
+			}
+		}
+		buf := make([]byte, n)
+		n, err = dr.Read(channel, buf)
+		switch {
+		case err == io.EOF:
+			return "EOF"
+		case err != nil:
+			return dr.Status().String()
+		}
+		return "DATA " + hex.EncodeToString(buf[:n])
+	case "WRITE":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return syntaxError
+		}
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return syntaxError
+		}
+		data, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return syntaxError
+		}
+		if _, err = dr.Write(channel, data); err != nil || channel == 15 {
+			return dr.Status().String()
+		}
+		return d64.Status{}.String()
+	case "CLOSE":
+		channel, err := strconv.Atoi(strings.TrimSpace(args))
+		if err != nil {
+			return syntaxError
+		}
+		return dr.Close(channel).String()
+	case "CMD":
+		return dr.Exec(args).String()
+	case "STATUS":
+		return dr.Status().String()
+	case "BREAD":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return syntaxError
+		}
+		track, sector, err := parseTrackSector(fields[0] + "," + fields[1])
+		if err != nil {
+			return syntaxError
+		}
+		data, status := dr.ReadBlock(track, sector)
+		if !status.OK() {
+			return status.String()
+		}
+		return "DATA " + hex.EncodeToString(data)
+	case "BWRITE":
+		fields := strings.Fields(args)
+		if len(fields) != 3 {
+			return syntaxError
+		}
+		track, sector, err := parseTrackSector(fields[0] + "," + fields[1])
+		if err != nil {
+			return syntaxError
+		}
+		data, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return syntaxError
+		}
+		return dr.WriteBlock(track, sector, data).String()
+	}
+	return d64.Status{Code: d64.StatusInvalidCommand}.String()
+}
+
+// save writes the image to disk if it was modified through the drive.
+func (srv *driveServer) save() error {
+	if !srv.drive.Modified() {
+		return nil
+	}
+	if err := srv.drive.Disk.WriteFileWithBackups(srv.path, flagBackups); err != nil {
+		return fmt.Errorf("d.WriteFileWithBackups %q failed: %v", srv.path, err)
+	}
+	srv.drive.ResetModified()
+	if flagVerbose {
+		fmt.Printf("saved %q\n", srv.path)
+	}
+	return nil
+}
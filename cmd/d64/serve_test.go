@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/staD020/d64"
+)
+
+// testConn is the client side of an in-process connection to a driveServer.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestDriveServer(t *testing.T) (*driveServer, *testConn) {
+	path := copyTestImage(t)
+	d, err := d64.LoadDisk(path)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	srv := &driveServer{path: path, drive: d64.NewDrive(d)}
+	client, server := net.Pipe()
+	go srv.serveConn(server)
+	t.Cleanup(func() { client.Close() })
+	return srv, &testConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// send sends a request and returns the reply.
+func (c *testConn) send(request string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintln(c.conn, request); err != nil {
+		c.t.Fatalf("sending %q failed: %v", request, err)
+	}
+	reply, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("reading reply to %q failed: %v", request, err)
+	}
+	return strings.TrimRight(reply, "\n")
+}
+
+func TestServeProtocol(t *testing.T) {
+	srv, c := newTestDriveServer(t)
+	cases := []struct {
+		request, want string
+	}{
+		{"STATUS", "73,CBM DOS V2.6 1541,00,00"},
+		{"OPEN 0 MISSING", "62,FILE NOT FOUND,00,00"},
+		{"OPEN 1 NEW", "00, OK,00,00"},
+		{"WRITE 1 0108ea60", "00, OK,00,00"},
+		{"WRITE 1 xyz", "30,SYNTAX ERROR,00,00"},
+		{"CLOSE 1", "00, OK,00,00"},
+		{"OPEN 2 NEW", "00, OK,00,00"},
+		{"READ 2 2", "DATA 0108"},
+		{"READ 2", "DATA ea60"},
+		{"READ 2", "EOF"},
+		{"CLOSE 2", "00, OK,00,00"},
+		{"READ 2", "61,FILE NOT OPEN,00,00"},
+		{"CMD R0:RENAMED=NEW", "00, OK,00,00"},
+		{"OPEN 15 S0:RENAMED", "01, FILES SCRATCHED,01,00"},
+		{"READ 15", "DATA " + fmt.Sprintf("%x", "01, FILES SCRATCHED,01,00\r")},
+		{"BREAD 18 0", "DATA 1201"},
+		{"BREAD 36 0", "66,ILLEGAL TRACK OR SECTOR,36,00"},
+		{"BWRITE 1 0 00", "30,SYNTAX ERROR,00,00"},
+		{"BWRITE 1 0 " + strings.Repeat("ab", d64.SectorSize), "00, OK,00,00"},
+		{"OPEN 3 \u212a\u212a\u212a=P", "62,FILE NOT FOUND,00,00"},
+		{"FOO", "31,SYNTAX ERROR,00,00"},
+	}
+	for _, tc := range cases {
+		got := c.send(tc.request)
+		if strings.HasPrefix(tc.want, "DATA ") && strings.HasPrefix(got, tc.want) {
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q == %q, want %q", tc.request, got, tc.want)
+		}
+	}
+
+	d, err := d64.LoadDisk(srv.path)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	data, err := d.ReadSector(1, 0)
+	if err != nil || !bytes.Equal(data, bytes.Repeat([]byte{0xab}, d64.SectorSize)) {
+		t.Errorf("BWRITE was not saved to %q", srv.path)
+	}
+}
+
+func TestServeReadOnlyDoesNotSave(t *testing.T) {
+	srv, c := newTestDriveServer(t)
+	srv.mu.Lock()
+	srv.path = filepath.Join(srv.path, "missing", "test.d64")
+	srv.mu.Unlock()
+	for _, request := range []string{"STATUS", "OPEN 0 *", "READ 0", "CLOSE 0", "BREAD 18 0", "CMD I0"} {
+		if got := c.send(request); strings.HasPrefix(got, "ERROR") {
+			t.Errorf("%q == %q, read-only request wrote the image", request, got)
+		}
+	}
+	if got := c.send("CMD S0:*"); !strings.HasPrefix(got, "ERROR") {
+		t.Errorf("scratch to a missing path == %q, want ERROR", got)
+	}
+}
+
+func TestServeRecover(t *testing.T) {
+	srv, c := newTestDriveServer(t)
+	want, err := d64.LoadDisk(srv.path)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+
+	// leave the disk half-modified in memory and panic on the next directory read
+	srv.mu.Lock()
+	d := srv.drive.Disk
+	if err = d.WriteSector(1, 0, bytes.Repeat([]byte{0xab}, d64.SectorSize)); err != nil {
+		t.Fatalf("d.WriteSector failed: %v", err)
+	}
+	d.Tracks[d64.DirTrack-1].Sectors[1].SetTrackLink(d64.MaxTracks + 1)
+	d.WarningHandler = func(w d64.Warning) { panic(w.String()) }
+	srv.mu.Unlock()
+
+	if got := c.send("CMD S0:MISSING"); got != "20,READ ERROR,00,00" {
+		t.Errorf("panicking request == %q, want 20,READ ERROR,00,00", got)
+	}
+	if got := c.send("BWRITE 2 0 " + strings.Repeat("cd", d64.SectorSize)); got != "00, OK,00,00" {
+		t.Errorf("request after panic == %q, want 00, OK,00,00", got)
+	}
+
+	got, err := d64.LoadDisk(srv.path)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	sector, _ := got.ReadSector(1, 0)
+	wantSector, _ := want.ReadSector(1, 0)
+	if !bytes.Equal(sector, wantSector) || len(got.Directory()) != len(want.Directory()) {
+		t.Errorf("partial change of the panicking request was saved to %q", srv.path)
+	}
+}
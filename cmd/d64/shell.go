@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/staD020/d64"
+)
+
+// shellPrompt is printed before each command, %s is the current image.
+const shellPrompt = "%s> "
+
+// A shellImage is an image opened in the shell.
+type shellImage struct {
+	d        *d64.Disk
+	modified bool
+}
+
+// A shellCommand is a single command of the shell.
+type shellCommand struct {
+	usage string
+	help  string
+	run   func(sh *shell, args []string) error
+}
+
+// A shell is an interactive session on one or more images.
+type shell struct {
+	images  map[string]*shellImage
+	current string
+	history []string
+	out     io.Writer
+}
+
+var shellCommands map[string]shellCommand
+
+func init() {
+	shellCommands = map[string]shellCommand{
+		"dir":      {"dir [pattern]", "print the directory, or only the files matching a 1541 pattern", (*shell).dir},
+		"load":     {"load pattern [file.prg]", "extract the first file matching pattern to the local filesystem", (*shell).load},
+		"save":     {"save file.prg [name]", "add a local prg file to the image", (*shell).save},
+		"scratch":  {"scratch pattern", "delete all files matching pattern", (*shell).scratch},
+		"rename":   {"rename old new", "rename file old to new", (*shell).rename},
+		"copy":     {"copy pattern new|image.d64", "copy a file within the image, or all matching files to another image", (*shell).copy},
+		"validate": {"validate", "rebuild the BAM from the directory and file chains", (*shell).validate},
+		"bam":      {"bam", "print the BAM", (*shell).bam},
+		"cd":       {"cd image.d64", "switch to another image, it is created if it does not exist", (*shell).cd},
+		"images":   {"images", "list all open images", (*shell).listImages},
+		"write":    {"write", "write the current image", (*shell).write},
+		"history":  {"history", "print the command history, repeat a command with !n or !!", (*shell).printHistory},
+		"help":     {"help", "print this help", (*shell).help},
+	}
+}
+
+// runShell starts an interactive shell on the .d64 in args.
+func runShell(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	sh := &shell{images: map[string]*shellImage{}, out: os.Stdout}
+	if err := sh.cd([]string{args[0]}); err != nil {
+		return err
+	}
+
+	readLine := bufio.NewReader(os.Stdin).ReadString
+	if restore, err := rawTerminal(); err == nil {
+		defer restore()
+		readLine = sh.editLine
+		sh.out = crlfWriter{os.Stdout}
+	}
+	return sh.run(readLine)
+}
+
+// run executes the lines returned by readLine until the shell quits.
+// At the end of the input the shell quits, returning an error if there are unsaved changes.
+func (sh *shell) run(readLine func(delim byte) (string, error)) error {
+	for {
+		fmt.Fprintf(sh.out, shellPrompt, sh.current)
+		line, err := readLine('\n')
+		eof := err == io.EOF
+		if err != nil && !eof {
+			return fmt.Errorf("reading command failed: %v", err)
+		}
+		if eof {
+			fmt.Fprintln(sh.out)
+		}
+		quit, err := sh.exec(strings.TrimSpace(line))
+		if err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+		if quit {
+			return nil
+		}
+		if eof {
+			_, err = sh.exec("quit")
+			return err
+		}
+	}
+}
+
+// exec executes a single command line and returns true if the shell should quit.
+func (sh *shell) exec(line string) (quit bool, err error) {
+	if line == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		if line, err = sh.recall(line); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(sh.out, line)
+	}
+	sh.history = append(sh.history, line)
+
+	args, err := splitArgs(line)
+	if err != nil {
+		return false, err
+	}
+	switch args[0] {
+	case "quit", "exit", "quit!", "exit!":
+		var unsaved []string
+		for path, img := range sh.images {
+			if img.modified {
+				unsaved = append(unsaved, path)
+			}
+		}
+		if len(unsaved) > 0 && !strings.HasSuffix(args[0], "!") {
+			sort.Strings(unsaved)
+			return false, fmt.Errorf("unsaved changes in %s, write them or use %s! to quit anyway", strings.Join(unsaved, ", "), args[0])
+		}
+		return true, nil
+	}
+	cmd, ok := shellCommands[args[0]]
+	if !ok {
+		return false, fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	return false, cmd.run(sh, args[1:])
+}
+
+// recall returns the history entry referred to by !! or !n.
+func (sh *shell) recall(line string) (string, error) {
+	if len(sh.history) == 0 {
+		return "", fmt.Errorf("history is empty")
+	}
+	if line == "!!" {
+		return sh.history[len(sh.history)-1], nil
+	}
+	var n int
+	if _, err := fmt.Sscanf(line, "!%d", &n); err != nil || n < 1 || n > len(sh.history) {
+		return "", fmt.Errorf("no history entry %q", line)
+	}
+	return sh.history[n-1], nil
+}
+
+// splitArgs splits line on whitespace, double quotes group arguments containing spaces.
+func splitArgs(line string) (args []string, err error) {
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case r == ' ' && !quoted:
+			if inArg {
+				args, inArg = append(args, arg.String()), false
+				arg.Reset()
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// image returns the current image.
+func (sh *shell) image() *shellImage {
+	return sh.images[sh.current]
+}
+
+func (sh *shell) dir(args []string) error {
+	d := sh.image().d
+	if len(args) == 0 {
+		fmt.Fprint(sh.out, d)
+		return nil
+	}
+	found, err := d.Find(strings.Join(args, ","))
+	if err != nil {
+		return err
+	}
+	for _, e := range found {
+		fmt.Fprintf(sh.out, "%3d %-16q prg (tr %2d sec %2d)\n", e.BlockSize, e.Filename, e.Track, e.Sector)
+	}
+	fmt.Fprintf(sh.out, "%d files\n", len(found))
+	return nil
+}
+
+func (sh *shell) load(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s", shellCommands["load"].usage)
+	}
+	d := sh.image().d
+	found, err := d.Find(args[0])
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("pattern %q: %w", args[0], d64.ErrFileNotFound)
+	}
+	prg, err := d.Extract(found[0].Track, found[0].Sector)
+	if err != nil {
+		return err
+	}
+	path := found[0].Filename + ".prg"
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if err = os.WriteFile(path, prg, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "loaded %q to %q, %d bytes\n", found[0].Filename, path, len(prg))
+	return nil
+}
+
+func (sh *shell) save(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: %s", shellCommands["save"].usage)
+	}
+	name := strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	if len(args) == 2 {
+		name = args[1]
+	}
+	img := sh.image()
+	if err := img.d.AddFile(args[0], name); err != nil {
+		return err
+	}
+	img.modified = true
+	fmt.Fprintf(sh.out, "saved %q as %q, %d blocks free\n", args[0], d64.NormalizeFilename(name), img.d.BlocksFree())
+	return nil
+}
+
+func (sh *shell) scratch(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", shellCommands["scratch"].usage)
+	}
+	img := sh.image()
+	found, err := img.d.Find(args[0])
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("pattern %q: %w", args[0], d64.ErrFileNotFound)
+	}
+	n := 0
+	err = img.d.Update(func(d *d64.Disk) error {
+		seen := map[string]bool{}
+		for _, e := range found {
+			if seen[e.Filename] {
+				continue
+			}
+			seen[e.Filename] = true
+			m, err := d.Scratch(e.Filename)
+			if err != nil {
+				return err
+			}
+			n += m
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	img.modified = true
+	fmt.Fprintf(sh.out, "scratched %d files\n", n)
+	return nil
+}
+
+func (sh *shell) rename(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", shellCommands["rename"].usage)
+	}
+	img := sh.image()
+	if err := img.d.Rename(args[0], args[1]); err != nil {
+		return err
+	}
+	img.modified = true
+	return nil
+}
+
+func (sh *shell) copy(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s", shellCommands["copy"].usage)
+	}
+	src := sh.image()
+	found, err := src.d.Find(args[0])
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return fmt.Errorf("pattern %q: %w", args[0], d64.ErrFileNotFound)
+	}
+
+	if strings.HasSuffix(strings.ToLower(args[1]), ".d64") {
+		dst, err := sh.open(args[1])
+		if err != nil {
+			return err
+		}
+		err = dst.d.Update(func(d *d64.Disk) error {
+			for _, e := range found {
+				if fileExists(d, e.Filename) {
+					return fmt.Errorf("file %q: %w", e.Filename, d64.ErrFileExists)
+				}
+				prg, err := src.d.Extract(e.Track, e.Sector)
+				if err != nil {
+					return fmt.Errorf("extract %q failed: %w", e.Filename, err)
+				}
+				if err = d.AddPrg(e.Filename, prg); err != nil {
+					return fmt.Errorf("add %q failed: %w", e.Filename, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		dst.modified = true
+		fmt.Fprintf(sh.out, "copied %d files to %q\n", len(found), args[1])
+		return nil
+	}
+
+	if len(found) > 1 {
+		return fmt.Errorf("pattern %q matches %d files, copy within the image takes a single file", args[0], len(found))
+	}
+	name := d64.NormalizeFilename(args[1])
+	if fileExists(src.d, name) {
+		return fmt.Errorf("file %q: %w", name, d64.ErrFileExists)
+	}
+	prg, err := src.d.Extract(found[0].Track, found[0].Sector)
+	if err != nil {
+		return err
+	}
+	if err = src.d.AddPrg(name, prg); err != nil {
+		return err
+	}
+	src.modified = true
+	return nil
+}
+
+// fileExists returns true if d contains a file named name.
+func fileExists(d *d64.Disk, name string) bool {
+	name = d64.NormalizeFilename(name)
+	for _, e := range d.Directory() {
+		if d64.NormalizeFilename(e.Filename) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (sh *shell) validate(args []string) error {
+	img := sh.image()
+	if err := img.d.Validate(); err != nil {
+		return fmt.Errorf("d.Validate failed: %w", err)
+	}
+	img.modified = true
+	fmt.Fprintf(sh.out, "%d blocks free\n", img.d.BlocksFree())
+	return nil
+}
+
+func (sh *shell) bam(args []string) error {
+	_, err := sh.image().d.PrintBAMTo(sh.out)
+	return err
+}
+
+// open returns the image at path, loading it or creating a new image if it is not open yet.
+func (sh *shell) open(path string) (*shellImage, error) {
+	if img, ok := sh.images[path]; ok {
+		return img, nil
+	}
+	d, err := d64.LoadDisk(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		label := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		img := &shellImage{d: d64.NewDisk(label, "01 2a", d64.DefaultSectorInterleave), modified: true}
+		sh.images[path] = img
+		fmt.Fprintf(sh.out, "created new image %q\n", path)
+		return img, nil
+	case err != nil:
+		return nil, err
+	}
+	sh.images[path] = &shellImage{d: d}
+	return sh.images[path], nil
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s", shellCommands["cd"].usage)
+	}
+	if _, err := sh.open(args[0]); err != nil {
+		return err
+	}
+	sh.current = args[0]
+	return nil
+}
+
+func (sh *shell) listImages(args []string) error {
+	paths := make([]string, 0, len(sh.images))
+	for path := range sh.images {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		flags := ""
+		if path == sh.current {
+			flags += " current"
+		}
+		if sh.images[path].modified {
+			flags += " modified"
+		}
+		fmt.Fprintf(sh.out, "%-24s %3d blocks free%s\n", path, sh.images[path].d.BlocksFree(), flags)
+	}
+	return nil
+}
+
+func (sh *shell) write(args []string) error {
+	img := sh.image()
+	if err := img.d.WriteFileWithBackups(sh.current, flagBackups); err != nil {
+		return err
+	}
+	img.modified = false
+	fmt.Fprintf(sh.out, "wrote %q\n", sh.current)
+	return nil
+}
+
+func (sh *shell) printHistory(args []string) error {
+	for i, line := range sh.history {
+		fmt.Fprintf(sh.out, "%4d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (sh *shell) help(args []string) error {
+	names := make([]string, 0, len(shellCommands))
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(sh.out, "%-28s %s\n", shellCommands[name].usage, shellCommands[name].help)
+	}
+	fmt.Fprintf(sh.out, "%-28s %s\n", "quit", "quit the shell, quit! discards unsaved changes")
+	return nil
+}
+
+// editLine reads a line from the raw terminal, with the up and down keys browsing the history.
+// delim is ignored, it matches the signature of bufio.Reader.ReadString.
+func (sh *shell) editLine(delim byte) (string, error) {
+	line, pos := "", len(sh.history)
+	for {
+		key, err := readKey(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case key == keyEnter:
+			fmt.Fprint(os.Stdout, "\r\n")
+			return line, nil
+		case key == 0x03 || key == 0x04:
+			if line == "" {
+				return "", io.EOF
+			}
+			line = ""
+		case key == keyBackspace:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case key == keyUp && pos > 0:
+			pos--
+			line = sh.history[pos]
+		case key == keyDown && pos < len(sh.history):
+			pos++
+			line = ""
+			if pos < len(sh.history) {
+				line = sh.history[pos]
+			}
+		case key >= 0x20 && key < 0x7f:
+			line += string(rune(key))
+		}
+		fmt.Fprintf(os.Stdout, "\r\x1b[K"+shellPrompt+"%s", sh.current, line)
+	}
+}
+
+// crlfWriter translates \n to \r\n, for output to a terminal in raw mode.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write([]byte(strings.ReplaceAll(string(p), "\n", "\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
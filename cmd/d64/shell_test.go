@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/staD020/d64"
+)
+
+func newTestShell(t *testing.T) (*shell, *bytes.Buffer) {
+	path := copyTestImage(t)
+	out := &bytes.Buffer{}
+	sh := &shell{images: map[string]*shellImage{}, out: out}
+	if err := sh.cd([]string{path}); err != nil {
+		t.Fatalf("sh.cd %q failed: %v", path, err)
+	}
+	return sh, out
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"dir", []string{"dir"}},
+		{"  rename  one   two ", []string{"rename", "one", "two"}},
+		{`save "my file.prg" "new name"`, []string{"save", "my file.prg", "new name"}},
+		{`dir ""`, []string{"dir", ""}},
+		{`copy a"b c"d`, []string{"copy", "ab cd"}},
+	}
+	for _, c := range cases {
+		got, err := splitArgs(c.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q) failed: %v", c.line, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(c.want, "|") || len(got) != len(c.want) {
+			t.Errorf("splitArgs(%q) == %q, want %q", c.line, got, c.want)
+		}
+	}
+	if _, err := splitArgs(`dir "unterminated`); err == nil {
+		t.Errorf("splitArgs with an unterminated quote should have failed")
+	}
+}
+
+func TestShellRecall(t *testing.T) {
+	sh, _ := newTestShell(t)
+	if _, err := sh.recall("!!"); err == nil {
+		t.Errorf("sh.recall on empty history should have failed")
+	}
+	sh.history = []string{"dir", "bam", "images"}
+	cases := []struct {
+		line, want string
+	}{
+		{"!!", "images"},
+		{"!1", "dir"},
+		{"!3", "images"},
+	}
+	for _, c := range cases {
+		if got, err := sh.recall(c.line); err != nil || got != c.want {
+			t.Errorf("sh.recall(%q) == %q, %v, want %q", c.line, got, err, c.want)
+		}
+	}
+	for _, line := range []string{"!0", "!4", "!x"} {
+		if _, err := sh.recall(line); err == nil {
+			t.Errorf("sh.recall(%q) should have failed", line)
+		}
+	}
+
+	if _, err := sh.exec("!2"); err != nil {
+		t.Fatalf("sh.exec(%q) failed: %v", "!2", err)
+	}
+	if got := sh.history[len(sh.history)-1]; got != "bam" {
+		t.Errorf("recalled command stored in history as %q, want %q", got, "bam")
+	}
+}
+
+func TestShellExec(t *testing.T) {
+	sh, out := newTestShell(t)
+	if _, err := sh.exec("dir"); err != nil || !strings.Contains(out.String(), "blocks free") {
+		t.Errorf("sh.exec(%q) == %v, output %q", "dir", err, out)
+	}
+	if _, err := sh.exec("foo"); err == nil {
+		t.Errorf("unknown command should have failed")
+	}
+	if _, err := sh.exec("rename"); err == nil {
+		t.Errorf("rename without arguments should have failed")
+	}
+	if quit, err := sh.exec("quit"); !quit || err != nil {
+		t.Errorf("quit without changes == %v, %v, want true", quit, err)
+	}
+}
+
+func TestShellQuitUnsaved(t *testing.T) {
+	sh, _ := newTestShell(t)
+	name := sh.image().d.Directory()[0].Filename
+	if _, err := sh.exec(`rename "` + name + `" renamed`); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	for _, line := range []string{"quit", "exit"} {
+		if quit, err := sh.exec(line); quit || err == nil {
+			t.Errorf("%s with unsaved changes == %v, %v, want an error", line, quit, err)
+		}
+	}
+	if quit, err := sh.exec("quit!"); !quit || err != nil {
+		t.Errorf("quit! == %v, %v, want true", quit, err)
+	}
+
+	if _, err := sh.exec("write"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if quit, err := sh.exec("quit"); !quit || err != nil {
+		t.Errorf("quit after write == %v, %v, want true", quit, err)
+	}
+	d, err := d64.LoadDisk(sh.current)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	if _, err = d.ReadFile("renamed"); err != nil {
+		t.Errorf("renamed file was not written: %v", err)
+	}
+}
+
+func TestShellCopy(t *testing.T) {
+	sh, _ := newTestShell(t)
+	dir := sh.image().d.Directory()
+	if len(dir) < 2 {
+		t.Fatalf("test image has %d files, want at least 2", len(dir))
+	}
+	if _, err := sh.exec("copy * new"); err == nil {
+		t.Errorf("copy of multiple files within the image should have failed")
+	}
+	if _, err := sh.exec(`copy "` + dir[0].Filename + `" "` + dir[1].Filename + `"`); !errors.Is(err, d64.ErrFileExists) {
+		t.Errorf("copy to an existing file == %v, want %v", err, d64.ErrFileExists)
+	}
+	if len(sh.image().d.Directory()) != len(dir) || sh.image().modified {
+		t.Errorf("failed copy modified the image")
+	}
+	if _, err := sh.exec(`copy "` + dir[0].Filename + `" new`); err != nil {
+		t.Fatalf("copy failed: %v", err)
+	}
+	if len(sh.image().d.Directory()) != len(dir)+1 || !sh.image().modified {
+		t.Errorf("copy did not add the file")
+	}
+
+	other := filepath.Join(filepath.Dir(sh.current), "other.d64")
+	if _, err := sh.exec("copy * " + other); err != nil {
+		t.Fatalf("copy to %q failed: %v", other, err)
+	}
+	if got := len(sh.images[other].d.Directory()); got != len(dir)+1 {
+		t.Errorf("%q has %d files, want %d", other, got, len(dir)+1)
+	}
+	if _, err := sh.exec("copy new " + other); !errors.Is(err, d64.ErrFileExists) {
+		t.Errorf("second copy to %q == %v, want %v", other, err, d64.ErrFileExists)
+	}
+}
+
+func TestShellRunEOF(t *testing.T) {
+	sh, out := newTestShell(t)
+	if err := sh.run(bufio.NewReader(strings.NewReader("dir\nbam")).ReadString); err != nil {
+		t.Errorf("sh.run without changes failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "18: ") {
+		t.Errorf("last line without newline was not executed: %q", out)
+	}
+
+	sh, _ = newTestShell(t)
+	name := sh.image().d.Directory()[0].Filename
+	if err := sh.run(bufio.NewReader(strings.NewReader(`rename "` + name + `" renamed` + "\n")).ReadString); err == nil {
+		t.Errorf("sh.run ending with unsaved changes should have failed")
+	}
+	if err := sh.run(bufio.NewReader(strings.NewReader("quit!\n")).ReadString); err != nil {
+		t.Errorf("sh.run with quit! failed: %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/staD020/d64"
+)
+
+// ANSI escape sequences used by the sector editor.
+const (
+	ansiClear   = "\x1b[H\x1b[2J"
+	ansiReverse = "\x1b[7m"
+	ansiBold    = "\x1b[1m"
+	ansiCyan    = "\x1b[36m"
+	ansiYellow  = "\x1b[33m"
+	ansiReset   = "\x1b[0m"
+)
+
+// Keys decoded from the terminal input, besides plain characters.
+const (
+	keyUp = iota + 0x100
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyEscape
+	keyEnter
+	keyBackspace
+)
+
+// An editor is the state of the interactive sector editor.
+type editor struct {
+	d        *d64.Disk
+	path     string
+	track    byte
+	sector   byte
+	cursor   int
+	data     []byte
+	orig     []byte
+	edit     bool
+	nibble   int
+	modified bool
+	// saved holds the content as last written of each sector edited since, to tell if an undo removed all changes.
+	saved map[d64.TrackSector][]byte
+	// quitArmed is set after q was pressed once with unsaved changes.
+	quitArmed bool
+	message   string
+	history   []d64.TrackSector
+	owners    map[d64.TrackSector]string
+	out       io.Writer
+}
+
+// editD64 runs the interactive sector editor on the .d64 in args.
+func editD64(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a single .d64, got %d arguments", len(args))
+	}
+	d, err := d64.LoadDisk(args[0])
+	if err != nil {
+		return fmt.Errorf("d64.LoadDisk %q failed: %v", args[0], err)
+	}
+	restore, err := rawTerminal()
+	if err != nil {
+		return fmt.Errorf("rawTerminal failed: %v", err)
+	}
+	defer restore()
+
+	e := &editor{d: d, path: args[0], out: os.Stdout}
+	if err = e.goTo(d64.DirTrack, 0, false); err != nil {
+		return err
+	}
+	defer fmt.Fprint(e.out, ansiClear)
+	for {
+		e.draw()
+		key, err := readKey(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("readKey failed: %v", err)
+		}
+		if quit := e.handle(key); quit {
+			return nil
+		}
+	}
+}
+
+// rawTerminal switches the terminal to raw mode with stty and returns a func restoring the previous mode.
+func rawTerminal() (restore func(), err error) {
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = os.Stdin
+	state, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("stty -g failed, not a terminal? %v", err)
+	}
+	cmd = exec.Command("stty", "raw", "-echo")
+	cmd.Stdin = os.Stdin
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("stty raw failed: %v", err)
+	}
+	return func() {
+		cmd := exec.Command("stty", strings.TrimSpace(string(state)))
+		cmd.Stdin = os.Stdin
+		_ = cmd.Run()
+	}, nil
+}
+
+// readKey reads a single key press from r, decoding ANSI escape sequences for cursor and page keys.
+func readKey(r io.Reader) (int, error) {
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	buf = buf[:n]
+	switch {
+	case bytes.Equal(buf, []byte("\x1b[A")):
+		return keyUp, nil
+	case bytes.Equal(buf, []byte("\x1b[B")):
+		return keyDown, nil
+	case bytes.Equal(buf, []byte("\x1b[C")):
+		return keyRight, nil
+	case bytes.Equal(buf, []byte("\x1b[D")):
+		return keyLeft, nil
+	case bytes.Equal(buf, []byte("\x1b[5~")):
+		return keyPageUp, nil
+	case bytes.Equal(buf, []byte("\x1b[6~")):
+		return keyPageDown, nil
+	case buf[0] == 0x1b:
+		return keyEscape, nil
+	case buf[0] == '\r' || buf[0] == '\n':
+		return keyEnter, nil
+	case buf[0] == 0x7f || buf[0] == 0x08:
+		return keyBackspace, nil
+	}
+	return int(buf[0]), nil
+}
+
+// goTo loads track, sector into the editor, pushing the current sector on the history if remember is set.
+func (e *editor) goTo(track, sector byte, remember bool) error {
+	data, err := e.d.ReadSector(track, sector)
+	if err != nil {
+		return err
+	}
+	if remember && e.data != nil {
+		e.history = append(e.history, d64.TrackSector{Track: e.track, Sector: e.sector})
+	}
+	e.track, e.sector, e.data, e.orig = track, sector, data, append([]byte(nil), data...)
+	e.nibble = 0
+	e.updateOwners()
+	return nil
+}
+
+// updateOwners maps all sectors in use by files, the BAM and the directory to their owner.
+func (e *editor) updateOwners() {
+	e.owners = map[d64.TrackSector]string{{Track: d64.DirTrack, Sector: 0}: "BAM"}
+	track, sector := byte(d64.DirTrack), byte(1)
+	for i := 0; i < int(e.d.Tracks[d64.DirTrack-1].TotalSectors()); i++ {
+		ts := d64.TrackSector{Track: track, Sector: sector}
+		if _, seen := e.owners[ts]; seen {
+			break
+		}
+		e.owners[ts] = "directory"
+		data, err := e.d.ReadSector(track, sector)
+		if err != nil || data[0] == 0 {
+			break
+		}
+		track, sector = data[0], data[1]
+	}
+	for _, de := range e.d.Directory() {
+		chain, _ := e.d.Chain(de)
+		for _, cs := range chain {
+			e.owners[cs.TrackSector] = fmt.Sprintf("file %q", de.Filename)
+		}
+	}
+}
+
+// totalSectors returns the amount of sectors on track.
+func (e *editor) totalSectors(track byte) byte {
+	return e.d.Tracks[track-1].TotalSectors()
+}
+
+// handle processes a single key press and returns true if the editor should quit.
+func (e *editor) handle(key int) (quit bool) {
+	msg := ""
+	defer func() { e.message = msg }()
+	if key != 'q' {
+		e.quitArmed = false
+	}
+	switch key {
+	case keyUp:
+		e.move(-dumpPerLine)
+		return false
+	case keyDown:
+		e.move(dumpPerLine)
+		return false
+	case keyLeft:
+		e.move(-1)
+		return false
+	case keyRight:
+		e.move(1)
+		return false
+	}
+
+	if e.edit {
+		switch {
+		case key == keyEscape:
+			e.edit = false
+		case key == keyBackspace:
+			e.move(-1)
+		case isHexDigit(key):
+			if err := e.poke(hexValue(key)); err != nil {
+				msg = err.Error()
+			}
+		default:
+			msg = "edit mode: type hex digits, Esc to stop editing"
+		}
+		return false
+	}
+
+	var err error
+	switch key {
+	case '>', '.', keyPageDown:
+		err = e.step(1)
+	case '<', ',', keyPageUp:
+		err = e.step(-1)
+	case ']':
+		if e.track < d64.MaxTracks {
+			err = e.goTo(e.track+1, 0, true)
+		}
+	case '[':
+		if e.track > 1 {
+			err = e.goTo(e.track-1, 0, true)
+		}
+	case 'l', keyEnter:
+		if e.data[0] == 0 {
+			msg = "last sector of the chain, no link to follow"
+			break
+		}
+		err = e.goTo(e.data[0], e.data[1], true)
+	case 'b':
+		if len(e.history) == 0 {
+			msg = "history is empty"
+			break
+		}
+		ts := e.history[len(e.history)-1]
+		e.history = e.history[:len(e.history)-1]
+		err = e.goTo(ts.Track, ts.Sector, false)
+	case 'g':
+		err = e.prompt()
+	case 'e':
+		e.edit, e.nibble = true, 0
+	case 'u':
+		copy(e.data, e.orig)
+		if err = e.d.WriteSector(e.track, e.sector, e.data); err == nil {
+			e.modified = e.unsaved()
+			e.updateOwners()
+			msg = "sector restored"
+		}
+	case 'w':
+		if err = e.d.WriteFileWithBackups(e.path, flagBackups); err == nil {
+			e.modified, e.saved = false, nil
+			msg = fmt.Sprintf("wrote %q", e.path)
+		}
+	case 'q':
+		if e.modified && !e.quitArmed {
+			e.quitArmed = true
+			msg = "unsaved changes, press q again to quit"
+			return false
+		}
+		return true
+	default:
+		msg = "unknown key, see the key help below"
+	}
+	if err != nil {
+		msg = err.Error()
+	}
+	return false
+}
+
+// move moves the cursor by delta bytes within the sector.
+func (e *editor) move(delta int) {
+	e.cursor = (e.cursor + delta + d64.SectorSize) % d64.SectorSize
+	e.nibble = 0
+}
+
+// step moves delta sectors forward or backward, continuing on the next or previous track.
+func (e *editor) step(delta int) error {
+	track, sector := int(e.track), int(e.sector)+delta
+	if sector >= int(e.totalSectors(e.track)) {
+		track, sector = track+1, 0
+	}
+	if sector < 0 {
+		track--
+		if track >= 1 {
+			sector = int(e.totalSectors(byte(track))) - 1
+		}
+	}
+	if track < 1 || track > d64.MaxTracks {
+		return fmt.Errorf("no more sectors")
+	}
+	return e.goTo(byte(track), byte(sector), true)
+}
+
+// poke sets the current nibble of the byte under the cursor to v and writes the sector to the disk.
+func (e *editor) poke(v byte) error {
+	b := e.data[e.cursor]
+	if e.nibble == 0 {
+		b = b&0x0f | v<<4
+	} else {
+		b = b&0xf0 | v
+	}
+	e.data[e.cursor] = b
+	ts := d64.TrackSector{Track: e.track, Sector: e.sector}
+	if _, ok := e.saved[ts]; !ok {
+		saved, err := e.d.ReadSector(e.track, e.sector)
+		if err != nil {
+			return err
+		}
+		if e.saved == nil {
+			e.saved = map[d64.TrackSector][]byte{}
+		}
+		e.saved[ts] = saved
+	}
+	if err := e.d.WriteSector(e.track, e.sector, e.data); err != nil {
+		return err
+	}
+	e.modified = e.unsaved()
+	e.updateOwners()
+	if e.nibble == 1 {
+		e.move(1)
+		return nil
+	}
+	e.nibble = 1
+	return nil
+}
+
+// unsaved returns true if any edited sector differs from its content as last written.
+func (e *editor) unsaved() bool {
+	for ts, saved := range e.saved {
+		data, err := e.d.ReadSector(ts.Track, ts.Sector)
+		if err != nil || !bytes.Equal(data, saved) {
+			return true
+		}
+	}
+	return false
+}
+
+// prompt reads track,sector from the terminal and goes there.
+func (e *editor) prompt() error {
+	input := ""
+	for {
+		fmt.Fprintf(e.out, "\r\x1b[Kgoto track,sector: %s", input)
+		key, err := readKey(os.Stdin)
+		if err != nil {
+			return err
+		}
+		switch {
+		case key == keyEnter:
+			track, sector, err := parseTrackSector(input)
+			if err != nil {
+				return err
+			}
+			return e.goTo(track, sector, true)
+		case key == keyEscape:
+			return nil
+		case key == keyBackspace:
+			if len(input) > 0 {
+				input = input[:len(input)-1]
+			}
+		case key < 0x100 && (isHexDigit(key) || key == ',' || key == 'x' || key == '$'):
+			input += string(rune(key))
+		}
+	}
+}
+
+// draw renders the editor screen.
+func (e *editor) draw() {
+	b := &strings.Builder{}
+	b.WriteString(ansiClear)
+	owner, ok := e.owners[d64.TrackSector{Track: e.track, Sector: e.sector}]
+	if !ok {
+		owner = "not part of any file"
+	}
+	status := ""
+	if e.modified {
+		status = "  " + ansiYellow + "[modified]" + ansiReset
+	}
+	fmt.Fprintf(b, "%s%s%s  track %d sector %d  %s%s%s%s\r\n\r\n", ansiBold, e.path, ansiReset, e.track, e.sector, ansiCyan, owner, ansiReset, status)
+	b.WriteString("     00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f  0123456789abcdef\r\n")
+	for offset := 0; offset < d64.SectorSize; offset += dumpPerLine {
+		fmt.Fprintf(b, "%02x: ", offset)
+		for i := offset; i < offset+dumpPerLine; i++ {
+			on, off := e.highlight(i)
+			fmt.Fprintf(b, "%s%02x%s ", on, e.data[i], off)
+		}
+		b.WriteString(" ")
+		for i := offset; i < offset+dumpPerLine; i++ {
+			on, off := e.highlight(i)
+			fmt.Fprintf(b, "%s%c%s", on, petscii(e.data[i]), off)
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	if e.data[0] == 0 {
+		fmt.Fprintf(b, "last sector of the chain, next free byte at 0x%02x\r\n", int(e.data[1])+1)
+	} else {
+		link := d64.TrackSector{Track: e.data[0], Sector: e.data[1]}
+		linkOwner, ok := e.owners[link]
+		if !ok {
+			linkOwner = "not part of any file"
+		}
+		fmt.Fprintf(b, "link to track %d sector %d: %s\r\n", link.Track, link.Sector, linkOwner)
+	}
+	mode := "view"
+	if e.edit {
+		mode = ansiYellow + "edit" + ansiReset + ", hex digits overwrite the byte under the cursor, Esc stops editing"
+	}
+	fmt.Fprintf(b, "cursor 0x%02x  mode %s\r\n", e.cursor, mode)
+	b.WriteString("arrows move  </> sector  [/] track  g goto  l/enter follow link  b back  e edit  u undo  w write  q quit\r\n")
+	if e.message != "" {
+		fmt.Fprintf(b, "%s%s%s\r\n", ansiYellow, e.message, ansiReset)
+	}
+	fmt.Fprint(e.out, b.String())
+}
+
+// highlight returns the ANSI sequences to start and end highlighting offset i, if it is under the cursor.
+func (e *editor) highlight(i int) (on, off string) {
+	if i == e.cursor {
+		return ansiReverse, ansiReset
+	}
+	return "", ""
+}
+
+func isHexDigit(key int) bool {
+	return key >= '0' && key <= '9' || key >= 'a' && key <= 'f' || key >= 'A' && key <= 'F'
+}
+
+func hexValue(key int) byte {
+	switch {
+	case key >= 'a':
+		return byte(key - 'a' + 10)
+	case key >= 'A':
+		return byte(key - 'A' + 10)
+	}
+	return byte(key - '0')
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/staD020/d64"
+)
+
+func newTestEditor(t *testing.T) *editor {
+	path := copyTestImage(t)
+	d, err := d64.LoadDisk(path)
+	if err != nil {
+		t.Fatalf("d64.LoadDisk failed: %v", err)
+	}
+	e := &editor{d: d, path: path, out: &bytes.Buffer{}}
+	if err = e.goTo(1, 0, false); err != nil {
+		t.Fatalf("e.goTo failed: %v", err)
+	}
+	return e
+}
+
+// press sends each key to e.handle and returns true if the last one quit the editor.
+func press(e *editor, keys ...int) (quit bool) {
+	for _, key := range keys {
+		quit = e.handle(key)
+	}
+	return quit
+}
+
+func TestEditorStep(t *testing.T) {
+	e := newTestEditor(t)
+	cases := []struct {
+		track, sector byte
+		delta         int
+		wantTrack     byte
+		wantSector    byte
+		wantErr       bool
+	}{
+		{1, 0, 1, 1, 1, false},
+		{1, 20, 1, 2, 0, false},
+		{2, 0, -1, 1, 20, false},
+		{1, 0, -1, 0, 0, true},
+		{d64.MaxTracks, 16, 1, 0, 0, true},
+	}
+	for _, c := range cases {
+		if err := e.goTo(c.track, c.sector, false); err != nil {
+			t.Fatalf("e.goTo failed: %v", err)
+		}
+		err := e.step(c.delta)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("e.step(%d) from track %d sector %d should have failed", c.delta, c.track, c.sector)
+			}
+			continue
+		}
+		if err != nil || e.track != c.wantTrack || e.sector != c.wantSector {
+			t.Errorf("e.step(%d) from track %d sector %d went to track %d sector %d, %v, want track %d sector %d",
+				c.delta, c.track, c.sector, e.track, e.sector, err, c.wantTrack, c.wantSector)
+		}
+	}
+}
+
+func TestEditorPoke(t *testing.T) {
+	e := newTestEditor(t)
+	e.cursor = 5
+	if err := e.poke(0xa); err != nil {
+		t.Fatalf("e.poke failed: %v", err)
+	}
+	if err := e.poke(0xb); err != nil {
+		t.Fatalf("e.poke failed: %v", err)
+	}
+	data, err := e.d.ReadSector(1, 0)
+	if err != nil {
+		t.Fatalf("e.d.ReadSector failed: %v", err)
+	}
+	if data[5] != 0xab || e.data[5] != 0xab {
+		t.Errorf("poked byte == %#02x, want 0xab", data[5])
+	}
+	if e.cursor != 6 || e.nibble != 0 || !e.modified {
+		t.Errorf("after poke cursor %d nibble %d modified %v, want 6, 0 and true", e.cursor, e.nibble, e.modified)
+	}
+}
+
+func TestEditorHandle(t *testing.T) {
+	e := newTestEditor(t)
+	press(e, keyRight, keyDown, keyLeft, keyUp, keyLeft)
+	if e.cursor != d64.SectorSize-1 {
+		t.Errorf("cursor == %d, want %d", e.cursor, d64.SectorSize-1)
+	}
+	press(e, '>', ']', '<')
+	if e.track != 1 || e.sector != 20 {
+		t.Errorf("after > ] < at track %d sector %d, want track 1 sector 20", e.track, e.sector)
+	}
+	press(e, 'b', 'b')
+	if e.track != 1 || e.sector != 1 || len(e.history) != 1 {
+		t.Errorf("after b b at track %d sector %d with %d history, want track 1 sector 1", e.track, e.sector, len(e.history))
+	}
+
+	if err := e.goTo(d64.DirTrack, 1, false); err != nil {
+		t.Fatalf("e.goTo failed: %v", err)
+	}
+	if press(e, 'l'); e.message == "" {
+		t.Errorf("following the link of the last directory sector should set a message")
+	}
+	if press(e, 'x'); e.message == "" {
+		t.Errorf("unknown key should set a message")
+	}
+	if press(e, 'e', '1', keyEscape, '2'); e.edit || e.data[e.cursor] != 0x10|e.orig[e.cursor]&0x0f {
+		t.Errorf("edit mode got %v, byte %#02x", e.edit, e.data[e.cursor])
+	}
+	if quit := press(e, 'q'); quit || e.message == "" {
+		t.Errorf("first q with unsaved changes quit the editor")
+	}
+	if quit := press(e, 'q'); !quit {
+		t.Errorf("second q did not quit the editor")
+	}
+}
+
+func TestEditorUndo(t *testing.T) {
+	e := newTestEditor(t)
+	press(e, 'e', 'f', 'f', keyEscape)
+	if !e.modified {
+		t.Fatalf("edit did not set modified")
+	}
+	if press(e, 'u'); e.modified || !bytes.Equal(e.data, e.orig) {
+		t.Errorf("undo of the only edited sector left modified %v", e.modified)
+	}
+	if quit := press(e, 'q'); !quit {
+		t.Errorf("q after undo did not quit the editor")
+	}
+
+	e = newTestEditor(t)
+	press(e, 'e', 'f', 'f', keyEscape, '>', 'e', 'f', 'f', keyEscape, 'u')
+	if !e.modified {
+		t.Errorf("undo of one of two edited sectors cleared modified")
+	}
+	press(e, 'w')
+	if e.modified {
+		t.Errorf("write did not clear modified: %s", e.message)
+	}
+	press(e, 'e', '0', '0', keyEscape, 'u')
+	if e.modified {
+		t.Errorf("undo after write left modified")
+	}
+}
@@ -5,21 +5,23 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 )
 
-const Version = "0.4"
+const Version = "0.5"
 
 // Definitions of .d64 requirements.
 const (
 	BlockSize       = 254 // Usable bytes per sector (block)
 	MaxBlocks       = 664 // Max blocks per .d64 image
+	MaxDirEntries   = 144 // Max files per directory
 	MaxFilenameSize = 16
 	MaxDiskIDSize   = 5
+	ImageSize       = 174848 // Size in bytes of a 35 track .d64 image
 
 	MaxTracks               = 35
 	MaxSectors              = 21
@@ -27,6 +29,7 @@ const (
 	SectorSize              = 0x100
 	DefaultSectorInterleave = 10
 	DirInterleave           = 3
+	DefaultDirReserve       = 2
 	MaxSectorsForBam        = 24
 	FileIDMask              = 0b10111111
 	PrgFileID               = 0x82
@@ -34,9 +37,12 @@ const (
 )
 
 // A Sector represents a single sector.
+// Data is a SectorSize view on the image data of the Disk it belongs to, so copies of a Sector
+// share their Data with the Disk. Use ReadSector for a copy of the data.
+// A zero Sector has no Data, it allocates its own detached Data on the first link update.
 type Sector struct {
 	ID   byte
-	Data [SectorSize]byte
+	Data []byte
 }
 
 // A Track represents a single track, consisting of multiple Sectors.
@@ -46,12 +52,83 @@ type Track struct {
 }
 
 // A Disk represents a .d64 image.
+// The Sectors of a Disk opened by OpenDevice or OpenImage are read on demand,
+// use LoadAll before accessing Tracks directly.
 type Disk struct {
 	Label            string
 	DiskID           string
 	Tracks           []Track
 	SectorInterleave byte
-	bam              [MaxTracks][MaxSectorsForBam]bool
+	// UseDirTrack allows AddPrg to store files on DirTrack sectors outside of the directory reserve.
+	UseDirTrack bool
+	// DirReserve is the amount of DirTrack sectors, in directory order, kept free for the directory.
+	// Only used in combination with UseDirTrack, nil means DefaultDirReserve.
+	DirReserve *byte
+	// Allocation is the AllocationStrategy used to find free sectors for files, nil means LinearAllocation.
+	Allocation AllocationStrategy
+	// WarningHandler, if set, is called for each new Warning, in addition to collecting it in Warnings.
+	WarningHandler func(w Warning)
+	bam            [MaxTracks][MaxSectorsForBam]bool
+	// raw marks the sectors allocated by WriteRaw, which Validate keeps allocated.
+	raw [MaxTracks][MaxSectors]bool
+	dev *device
+	// data is the contiguous image data, viewed by the Sectors in Tracks.
+	data []byte
+	// warningsMu guards warnings and warningKeys, as warnings are collected by read-only methods.
+	warningsMu sync.Mutex
+	warnings   []Warning
+	// warningKeys holds the String of each collected warning, to ignore duplicates.
+	warningKeys map[string]bool
+}
+
+// AddOptions customizes the storage of a single file by AddPrgWithOptions.
+type AddOptions struct {
+	// UseDirTrack allows storage of this file on DirTrack sectors outside of the directory reserve.
+	UseDirTrack bool
+	// Interleave is the sector interleave of this file, zero means the SectorInterleave of the Disk.
+	Interleave byte
+	// Track and Sector pin the first sector of this file, zero Track means the first free sector is used.
+	Track  byte
+	Sector byte
+	// FirstTrack and LastTrack limit the storage of this file to a range of tracks, zero means no limit.
+	FirstTrack byte
+	LastTrack  byte
+}
+
+// inTrackRange returns true if track is within the FirstTrack and LastTrack limits of opt.
+func (opt AddOptions) inTrackRange(track byte) bool {
+	return (opt.FirstTrack == 0 || track >= opt.FirstTrack) && (opt.LastTrack == 0 || track <= opt.LastTrack)
+}
+
+// limited returns true if opt limits the storage to a range of tracks.
+func (opt AddOptions) limited() bool {
+	return opt.FirstTrack != 0 || opt.LastTrack != 0
+}
+
+// validate returns an error if the placement options are invalid.
+func (opt AddOptions) validate() error {
+	if opt.FirstTrack > MaxTracks || opt.LastTrack > MaxTracks {
+		return fmt.Errorf("illegal track range: %d-%d", opt.FirstTrack, opt.LastTrack)
+	}
+	if opt.LastTrack != 0 && opt.FirstTrack > opt.LastTrack {
+		return fmt.Errorf("illegal track range: %d-%d", opt.FirstTrack, opt.LastTrack)
+	}
+	if opt.Track == 0 {
+		return nil
+	}
+	if err := sectorIsValid(opt.Track, opt.Sector); err != nil {
+		return err
+	}
+	if !opt.inTrackRange(opt.Track) {
+		return fmt.Errorf("track %d is outside of track range %d-%d", opt.Track, opt.FirstTrack, opt.LastTrack)
+	}
+	return nil
+}
+
+// A TrackSector addresses a single sector on the disk.
+type TrackSector struct {
+	Track  byte `json:"track"`
+	Sector byte `json:"sector"`
 }
 
 // A DirEntry represents a single file in the directory of this d64.
@@ -64,27 +141,55 @@ type DirEntry struct {
 
 // TrackLink returns this sectors next track-link.
 func (s Sector) TrackLink() byte {
+	if len(s.Data) == 0 {
+		return 0
+	}
 	return s.Data[0]
 }
 
 // SetTrackLink sets the track-link for the next sector of this file.
 func (s *Sector) SetTrackLink(b byte) {
+	s.alloc()
 	s.Data[0] = b
 }
 
 // SectorLink returns this sectors next sector-link.
 func (s Sector) SectorLink() byte {
+	if len(s.Data) < 2 {
+		return 0
+	}
 	return s.Data[1]
 }
 
 // SetSectorLink sets the sector-link for the next sector of this file.
 func (s *Sector) SetSectorLink(b byte) {
+	s.alloc()
 	s.Data[1] = b
 }
 
+// alloc allocates detached Data for a zero Sector.
+func (s *Sector) alloc() {
+	if len(s.Data) < SectorSize {
+		s.Data = append(s.Data, make([]byte, SectorSize-len(s.Data))...)
+	}
+}
+
+// zero zerofills this sector.
+func (s *Sector) zero() {
+	for i := range s.Data {
+		s.Data[i] = 0
+	}
+}
+
 // Bytes returns the binary content of this sector, track&sector link are not included.
 func (s Sector) Bytes() []byte {
+	if len(s.Data) < SectorSize {
+		return nil
+	}
 	if s.TrackLink() == 0 && s.SectorLink()+1 != 0 {
+		if s.SectorLink() < 2 {
+			return s.Data[2:2]
+		}
 		return s.Data[2 : s.SectorLink()+1]
 	}
 	return s.Data[2:]
@@ -100,6 +205,18 @@ var sectorsPerTrack = [...]byte{
 	17, 17, 17, 17, 17, // 36-40 (not tested)
 }
 
+// sectorOffsets holds the offset in the .d64 of each track and sector.
+var sectorOffsets = func() (offsets [len(sectorsPerTrack)][MaxSectors]int) {
+	offset := 0
+	for track := 1; track < len(sectorsPerTrack); track++ {
+		for sector := 0; sector < int(sectorsPerTrack[track]); sector++ {
+			offsets[track][sector] = offset
+			offset += SectorSize
+		}
+	}
+	return offsets
+}()
+
 func totalSectors(track byte) byte {
 	return sectorsPerTrack[track]
 }
@@ -116,17 +233,13 @@ func LoadDisk(path string) (*Disk, error) {
 	if err != nil {
 		return d, fmt.Errorf("os.ReadFile %q failed: %w", path, err)
 	}
-
-	d.Tracks = make([]Track, MaxTracks)
-	for track := byte(1); track <= MaxTracks; track++ {
-		d.FormatTrack(track)
-		for sector := byte(0); sector < d.Tracks[track-1].TotalSectors(); sector++ {
-			offset := trackSectorToDataOffset(track, sector)
-			for i := 0; i < SectorSize; i++ {
-				d.Tracks[track-1].Sectors[sector].Data[i] = bin[offset+i]
-			}
-		}
+	if len(bin) < ImageSize {
+		return d, fmt.Errorf("file %q is too small for a .d64, %d bytes: %w", path, len(bin), ErrInvalidImage)
 	}
+
+	data := make([]byte, ImageSize)
+	copy(data, bin)
+	d.setData(data)
 	d.loadBAM()
 	d.guessInterleave()
 	return d, nil
@@ -134,11 +247,21 @@ func LoadDisk(path string) (*Disk, error) {
 
 // trackSectorToDataOffset returns the offset in the .d64 of the given track and sector.
 func trackSectorToDataOffset(track, sector byte) int {
-	offset := int(sector) * SectorSize
-	for t := byte(1); t < track; t++ {
-		offset += int(totalSectors(t)) * SectorSize
+	return sectorOffsets[track][sector]
+}
+
+// setData sets the image data of the disk and (re)creates all Tracks and their Sector views on data.
+func (d *Disk) setData(data []byte) {
+	d.data = data
+	d.Tracks = make([]Track, MaxTracks)
+	for track := byte(1); track <= MaxTracks; track++ {
+		t := Track{ID: track, Sectors: make([]Sector, totalSectors(track))}
+		for i := range t.Sectors {
+			offset := trackSectorToDataOffset(track, byte(i))
+			t.Sectors[i] = Sector{ID: byte(i), Data: data[offset : offset+SectorSize : offset+SectorSize]}
+		}
+		d.Tracks[track-1] = t
 	}
-	return offset
 }
 
 // NewDisk returns a new formatted *Disk.
@@ -147,8 +270,8 @@ func NewDisk(label, diskID string, interleave byte) *Disk {
 		Label:            label,
 		DiskID:           diskID,
 		SectorInterleave: interleave,
-		Tracks:           make([]Track, MaxTracks),
 	}
+	d.setData(make([]byte, ImageSize))
 	for track := byte(1); track <= MaxTracks; track++ {
 		d.FormatTrack(track)
 	}
@@ -159,66 +282,157 @@ func NewDisk(label, diskID string, interleave byte) *Disk {
 }
 
 // String implements the Stringer interface and returns a human readable directory.
-func (d Disk) String() string {
+func (d *Disk) String() string {
 	s := fmt.Sprintf("%q %q\n", d.Label, d.DiskID)
-	blocksFree := MaxBlocks
 	for _, e := range d.Directory() {
 		s += fmt.Sprintf("%3d %-16q prg (tr %2d sec %2d start 0x%04x)\n", e.BlockSize, e.Filename, e.Track, e.Sector, d.StartAddress(e))
-		blocksFree -= e.BlockSize
 	}
-	return s + fmt.Sprintf("%3d blocks free\n", blocksFree)
+	return s + fmt.Sprintf("%3d blocks free\n", d.BlocksFree())
+}
+
+// BlocksFree returns the amount of unallocated blocks, excluding the DirTrack.
+func (d *Disk) BlocksFree() (n int) {
+	for track := byte(1); track <= MaxTracks; track++ {
+		if track == DirTrack {
+			continue
+		}
+		for sector := byte(0); sector < totalSectors(track); sector++ {
+			if !d.bam[track-1][sector] {
+				n++
+			}
+		}
+	}
+	return n
 }
 
 // StartAddress extracts the start address from the first sector of the DirEntry.
-func (d Disk) StartAddress(e DirEntry) uint16 {
+func (d *Disk) StartAddress(e DirEntry) uint16 {
 	if e.Track > 0 {
-		return binary.LittleEndian.Uint16(d.Tracks[e.Track-1].Sectors[e.Sector].Data[2:4])
+		return binary.LittleEndian.Uint16(d.sector(e.Track, e.Sector).Data[2:4])
 	}
 	return 0
 }
 
 // WriteTo writes the disk to io.Writer, implementing the io.WriterTo interface.
-func (d Disk) WriteTo(w io.Writer) (int64, error) {
-	var n int64
-	for _, t := range d.Tracks {
-		for _, s := range t.Sectors {
-			m, err := w.Write(s.Data[:])
-			n += int64(m)
-			if err != nil {
-				return n, fmt.Errorf("w.Write failed: %w", err)
-			}
-		}
+func (d *Disk) WriteTo(w io.Writer) (int64, error) {
+	if err := d.LoadAll(); err != nil {
+		return 0, fmt.Errorf("d.LoadAll failed: %w", err)
 	}
-	return n, nil
+	n, err := w.Write(d.data)
+	if err != nil {
+		return int64(n), fmt.Errorf("w.Write failed: %w", err)
+	}
+	return int64(n), nil
+}
+
+// WriteFile atomically writes the disk to path.
+// The disk is written to a temporary file in the same directory, synced to disk and renamed to path,
+// so path is never left truncated.
+func (d *Disk) WriteFile(path string) error {
+	return d.WriteFileWithBackups(path, 0)
 }
 
-// WriteFile writes the disk to path.
-func (d Disk) WriteFile(path string) error {
-	f, err := os.Create(path)
+// WriteFileWithBackups atomically writes the disk to path, like WriteFile.
+// Up to backups previous versions of path are kept as path.bak, path.bak.2, path.bak.3 etc. with path.bak being the most recent.
+func (d *Disk) WriteFileWithBackups(path string, backups int) (err error) {
+	mode := os.FileMode(0644)
+	info, err := os.Stat(path)
+	exists := err == nil
+	if exists {
+		mode = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("os.Create %q failed: %w", path, err)
+		return fmt.Errorf("os.CreateTemp for %q failed: %w", path, err)
 	}
-	defer f.Close()
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
 	if _, err = d.WriteTo(f); err != nil {
-		return fmt.Errorf("d.WriteTo %q failed: %w", path, err)
+		return fmt.Errorf("d.WriteTo %q failed: %w", f.Name(), err)
+	}
+	if err = f.Chmod(mode); err != nil {
+		return fmt.Errorf("f.Chmod %q failed: %w", f.Name(), err)
+	}
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("f.Sync %q failed: %w", f.Name(), err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("f.Close %q failed: %w", f.Name(), err)
+	}
+
+	if exists && backups > 0 {
+		if err = rotateBackups(path, backups); err != nil {
+			return fmt.Errorf("rotateBackups %q failed: %w", path, err)
+		}
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename %q to %q failed: %w", f.Name(), path, err)
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// backupPath returns the path of backup n of path, starting at 1.
+func backupPath(path string, n int) string {
+	if n == 1 {
+		return path + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", path, n)
+}
+
+// rotateBackups shifts the existing backups of path, dropping the oldest, and backs up path as path.bak.
+// The original path is left in place.
+func rotateBackups(path string, backups int) error {
+	if err := os.Remove(backupPath(path, backups)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("os.Remove failed: %w", err)
+	}
+	for n := backups - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("os.Rename failed: %w", err)
+		}
+	}
+	bak := backupPath(path, 1)
+	if err := os.Link(path, bak); err == nil {
+		return nil
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("os.ReadFile failed: %w", err)
+	}
+	if err = os.WriteFile(bak, buf, 0644); err != nil {
+		return fmt.Errorf("os.WriteFile failed: %w", err)
 	}
 	return nil
 }
 
+// syncDir syncs the directory dir, making a rename durable. Errors are ignored, as not all platforms support it.
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = f.Sync()
+	f.Close()
+}
+
 // FormatTrack zerofills the track and marks its sectors free in d.bam.
 func (d *Disk) FormatTrack(id byte) {
-	t := Track{ID: id}
-	t.Sectors = make([]Sector, t.TotalSectors())
-	for i := byte(0); i < byte(len(t.Sectors)); i++ {
-		t.Sectors[i] = Sector{ID: i}
+	for i := byte(0); i < totalSectors(id); i++ {
+		d.sector(id, i).zero()
 		d.bam[id-1][i] = false
+		d.raw[id-1][i] = false
 	}
-	d.Tracks[t.ID-1] = t
 }
 
 // FormatBAM formats track 18 sector 0, sets disk name and initializes the BAM.
 func (d *Disk) FormatBAM() {
-	var s Sector
+	s := d.sector(DirTrack, 0)
+	s.zero()
 	s.SetTrackLink(DirTrack)
 	s.SetSectorLink(1)
 	s.Data[2] = byte('A')
@@ -244,7 +458,6 @@ func (d *Disk) FormatBAM() {
 		s.Data[i+0xa2] = byte(c)
 	}
 
-	d.Tracks[DirTrack-1].Sectors[0] = s
 	d.bam[DirTrack-1][0] = true
 	d.prepareBam()
 }
@@ -253,7 +466,7 @@ func (d *Disk) FormatBAM() {
 func (d *Disk) setLabelFromBAM() {
 	buf := [MaxFilenameSize]byte{}
 	for i := 0; i < MaxFilenameSize; i++ {
-		buf[i] = d.Tracks[DirTrack-1].Sectors[0].Data[0x90+i]
+		buf[i] = d.sector(DirTrack, 0).Data[0x90+i]
 	}
 	d.Label = ""
 	for i := range buf {
@@ -268,7 +481,7 @@ func (d *Disk) setLabelFromBAM() {
 func (d *Disk) setDiskIDFromBAM() {
 	buf := [MaxDiskIDSize]byte{}
 	for i := 0; i < MaxDiskIDSize; i++ {
-		buf[i] = d.Tracks[DirTrack-1].Sectors[0].Data[0xa2+i]
+		buf[i] = d.sector(DirTrack, 0).Data[0xa2+i]
 	}
 	d.DiskID = ""
 	for i := range buf {
@@ -283,7 +496,17 @@ var reStripSlashes = regexp.MustCompile("[/]")
 
 // ExtractToPath writes all files to outDir and returns a slice containing all paths.
 func (d *Disk) ExtractToPath(outDir string) (paths []string, err error) {
-	for i, e := range d.Directory() {
+	return d.ExtractMatchingToPath(outDir, "*")
+}
+
+// ExtractMatchingToPath writes all files matching pattern to outDir and returns a slice containing all paths.
+// See Find for the pattern syntax.
+func (d *Disk) ExtractMatchingToPath(outDir, pattern string) (paths []string, err error) {
+	found, err := d.Find(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range found {
 		filename := reStripSlashes.ReplaceAllString(e.Filename, "")
 		if filename == "" {
 			filename = fmt.Sprintf("file%d", i)
@@ -291,7 +514,7 @@ func (d *Disk) ExtractToPath(outDir string) (paths []string, err error) {
 		path := filepath.Join(outDir, filename+".prg")
 		prg, err := d.Extract(e.Track, e.Sector)
 		if err != nil {
-			log.Printf("warn: skipping file %q d64.Extract(%d, %d): %v", e.Filename, e.Track, e.Sector, err)
+			d.warn(Warning{Track: e.Track, Sector: e.Sector, Filename: e.Filename, Message: "skipping file, extract failed", Err: err})
 			continue
 		}
 		if err = os.WriteFile(path, prg, 0644); err != nil {
@@ -304,25 +527,11 @@ func (d *Disk) ExtractToPath(outDir string) (paths []string, err error) {
 
 // Extract returns the prg starting on track, sector.
 // Returns an error when there are issues with invalid track,sector links.
-func (d Disk) Extract(track, sector byte) (prg []byte, err error) {
-	if err = sectorIsValid(track, sector); err != nil {
-		return prg, err
-	}
-	used := [MaxTracks][MaxSectors]bool{}
-	for {
-		if used[track-1][sector] {
-			return prg, fmt.Errorf("loop detected on track %d, sector %d: it was already used in this file", track, sector)
-		}
-		s := d.Tracks[track-1].Sectors[sector]
-		prg = append(prg, s.Bytes()...)
-		used[track-1][sector] = true
-		if s.TrackLink() == 0 {
-			break
-		}
-		track, sector = s.TrackLink(), s.SectorLink()
-		if err = sectorIsValid(track, sector); err != nil {
-			return prg, err
-		}
+func (d *Disk) Extract(track, sector byte) (prg []byte, err error) {
+	chain, err := d.chain(track, sector)
+	prg = make([]byte, 0, len(chain)*BlockSize)
+	for _, ts := range chain {
+		prg = append(prg, d.sector(ts.Track, ts.Sector).Bytes()...)
 	}
 	return prg, err
 }
@@ -330,13 +539,13 @@ func (d Disk) Extract(track, sector byte) (prg []byte, err error) {
 // sectorIsValid returns an error if the track or sector is invalid.
 func sectorIsValid(track, sector byte) error {
 	if track < 1 || track > MaxTracks || sector >= totalSectors(track) {
-		return fmt.Errorf("illegal track or sector: %d, %d", track, sector)
+		return &IllegalSectorError{Track: track, Sector: sector}
 	}
 	return nil
 }
 
 // ExtractBoot returns the first prg found in the directory.
-func (d Disk) ExtractBoot() (prg []byte, err error) {
+func (d *Disk) ExtractBoot() (prg []byte, err error) {
 	boot := d.Directory()[0]
 	return d.Extract(boot.Track, boot.Sector)
 }
@@ -345,7 +554,7 @@ func (d Disk) ExtractBoot() (prg []byte, err error) {
 func (d *Disk) guessInterleave() {
 	d.SectorInterleave = DefaultSectorInterleave
 	for _, e := range d.Directory() {
-		s := d.Tracks[e.Track-1].Sectors[e.Sector]
+		s := d.sector(e.Track, e.Sector)
 		if e.Track == s.TrackLink() && e.Sector < s.SectorLink() {
 			d.SectorInterleave = s.SectorLink() - e.Sector
 			return
@@ -353,31 +562,201 @@ func (d *Disk) guessInterleave() {
 	}
 }
 
+// chain returns all sectors of the file starting on track, sector in order.
+// Returns an error when there are issues with invalid track,sector links.
+func (d *Disk) chain(track, sector byte) (chain []TrackSector, err error) {
+	if err = sectorIsValid(track, sector); err != nil {
+		return chain, err
+	}
+	used := [MaxTracks][MaxSectors]bool{}
+	for {
+		used[track-1][sector] = true
+		chain = append(chain, TrackSector{Track: track, Sector: sector})
+		s := d.sector(track, sector)
+		if s.TrackLink() == 0 {
+			return chain, nil
+		}
+		next, nextSector := s.TrackLink(), s.SectorLink()
+		if err = sectorIsValid(next, nextSector); err != nil {
+			return chain, &ChainError{Track: track, Sector: sector, Reason: "illegal link", Err: err}
+		}
+		if used[next-1][nextSector] {
+			reason := fmt.Sprintf("loop detected, track %d sector %d was already used in this file", next, nextSector)
+			return chain, &ChainError{Track: track, Sector: sector, Reason: reason}
+		}
+		track, sector = next, nextSector
+	}
+}
+
+// A ChainSector is a single sector in the sector chain of a file.
+type ChainSector struct {
+	TrackSector
+	// Bytes is the amount of file data stored in this sector.
+	Bytes int `json:"bytes"`
+}
+
+// Chain returns the sector chain of e in order, with the amount of file data stored in each sector.
+// On a broken chain, the sectors up to the broken link are returned together with the error.
+func (d *Disk) Chain(e DirEntry) ([]ChainSector, error) {
+	chain, err := d.chain(e.Track, e.Sector)
+	sectors := make([]ChainSector, 0, len(chain))
+	for _, ts := range chain {
+		sectors = append(sectors, ChainSector{TrackSector: ts, Bytes: len(d.sector(ts.Track, ts.Sector).Bytes())})
+	}
+	if err != nil {
+		return sectors, fmt.Errorf("d.chain %q failed: %w", e.Filename, err)
+	}
+	return sectors, nil
+}
+
+// FileInterleave returns the sector interleave detected in the sector chain of e.
+// The most common distance between consecutive sectors on the same track wins, ties are won by the smallest interleave.
+// Returns 0 if the file never links to a sector on the same track.
+func (d *Disk) FileInterleave(e DirEntry) (interleave byte, err error) {
+	chain, err := d.chain(e.Track, e.Sector)
+	if err != nil {
+		return 0, fmt.Errorf("d.chain %q failed: %w", e.Filename, err)
+	}
+	count := [MaxSectors]int{}
+	for i := 1; i < len(chain); i++ {
+		prev, cur := chain[i-1], chain[i]
+		if prev.Track != cur.Track {
+			continue
+		}
+		total := totalSectors(cur.Track)
+		count[(cur.Sector+total-prev.Sector)%total]++
+	}
+	for i := range count {
+		if count[i] > count[interleave] {
+			interleave = byte(i)
+		}
+	}
+	return interleave, nil
+}
+
 // directoryEntries returns the DirEntries of a specific (directory) sector.
 func (s Sector) directoryEntries() (dirEntries []DirEntry) {
-	for i := 2; i < SectorSize; i += 32 {
-		if s.Data[i]&FileIDMask != PrgFileID {
-			continue
+	for i := 2; i < SectorSize; i += dirEntrySize {
+		if e, ok := s.directoryEntry(i); ok {
+			dirEntries = append(dirEntries, e)
 		}
-		var filename string
-		for j := 0; j < MaxFilenameSize; j++ {
-			if s.Data[i+3+j] == AlternateSpaceCharacter {
-				break
+	}
+	return dirEntries
+}
+
+// directoryEntry returns the DirEntry at offset i of a (directory) sector.
+// Returns false if there is no valid prg file at offset i.
+func (s Sector) directoryEntry(i int) (DirEntry, bool) {
+	if s.Data[i]&FileIDMask != PrgFileID {
+		return DirEntry{}, false
+	}
+	var filename string
+	for j := 0; j < MaxFilenameSize; j++ {
+		if s.Data[i+3+j] == AlternateSpaceCharacter {
+			break
+		}
+		filename += string(s.Data[i+3+j])
+	}
+	track, sector := s.Data[i+1], s.Data[i+2]
+	if err := sectorIsValid(track, sector); err != nil {
+		return DirEntry{}, false
+	}
+	return DirEntry{
+		Filename:  NormalizeFilename(filename),
+		Track:     track,
+		Sector:    sector,
+		BlockSize: int(s.Data[i+28]) + int(s.Data[i+29])<<8,
+	}, true
+}
+
+// A dirSlot locates a DirEntry in the directory sectors.
+type dirSlot struct {
+	DirEntry
+	dir    TrackSector
+	offset int
+}
+
+// dirSlots returns the location of all .prg DirEntries in the directory.
+func (d *Disk) dirSlots() (slots []dirSlot) {
+	track, sector := byte(DirTrack), byte(1)
+	for n := byte(0); n < totalSectors(DirTrack); n++ {
+		s := d.sector(track, sector)
+		for i := 2; i < SectorSize; i += dirEntrySize {
+			if e, ok := s.directoryEntry(i); ok {
+				slots = append(slots, dirSlot{DirEntry: e, dir: TrackSector{track, sector}, offset: i})
 			}
-			filename += string(s.Data[i+3+j])
 		}
-		track, sector := s.Data[i+1], s.Data[i+2]
-		if err := sectorIsValid(track, sector); err != nil {
-			continue
+		if s.TrackLink() == 0 {
+			return slots
+		}
+		track, sector = s.TrackLink(), s.SectorLink()
+		if sectorIsValid(track, sector) != nil {
+			return slots
 		}
-		dirEntries = append(dirEntries, DirEntry{
-			Filename:  NormalizeFilename(filename),
-			Track:     track,
-			Sector:    sector,
-			BlockSize: int(s.Data[i+28]) + int(s.Data[i+29])<<8,
-		})
 	}
-	return dirEntries
+	return slots
+}
+
+// findSlots returns the slots of all files named filename.
+func (d *Disk) findSlots(filename string) (slots []dirSlot) {
+	name := NormalizeFilename(filename)
+	for _, slot := range d.dirSlots() {
+		if slot.Filename == name {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// Scratch deletes all files named filename and frees their sectors, returning the amount of scratched files.
+// Returns an error if the file does not exist.
+func (d *Disk) Scratch(filename string) (n int, err error) {
+	err = d.Update(func(d *Disk) error {
+		slots := d.findSlots(filename)
+		if len(slots) == 0 {
+			return fmt.Errorf("file %q: %w", filename, ErrFileNotFound)
+		}
+		for _, slot := range slots {
+			chain, err := d.chain(slot.Track, slot.Sector)
+			if err != nil {
+				return fmt.Errorf("file %q: %w", filename, err)
+			}
+			for _, ts := range chain {
+				d.bam[ts.Track-1][ts.Sector] = false
+			}
+			d.sector(slot.dir.Track, slot.dir.Sector).Data[slot.offset] = 0
+		}
+		n = len(slots)
+		d.setBamEntries()
+		return nil
+	})
+	return n, err
+}
+
+// Rename renames all files named oldName to newName.
+// Returns an error if oldName does not exist or newName already exists as another file.
+func (d *Disk) Rename(oldName, newName string) error {
+	return d.Update(func(d *Disk) error {
+		name := strings.ToUpper(NormalizeFilename(newName))
+		if NormalizeFilename(newName) != NormalizeFilename(oldName) && len(d.findSlots(name)) > 0 {
+			return fmt.Errorf("file %q: %w", newName, ErrFileExists)
+		}
+		slots := d.findSlots(oldName)
+		if len(slots) == 0 {
+			return fmt.Errorf("file %q: %w", oldName, ErrFileNotFound)
+		}
+		for _, slot := range slots {
+			s := d.sector(slot.dir.Track, slot.dir.Sector)
+			for j := 0; j < MaxFilenameSize; j++ {
+				c := byte(AlternateSpaceCharacter)
+				if j < len(name) {
+					c = name[j]
+				}
+				s.Data[slot.offset+3+j] = c
+			}
+		}
+		return nil
+	})
 }
 
 // addFileToDirectory adds filename to the directory, allocates a new sector if current ones are fully used.
@@ -387,14 +766,14 @@ func (d *Disk) addFileToDirectory(firstTrack, firstSector byte, filename string,
 		return fmt.Errorf("name %q too long", name)
 	}
 	defer d.setBamEntries()
-	track, sector := DirTrack, 1
+	track, sector := byte(DirTrack), byte(1)
 
 	// find empty spot in current dir sectors
-	for k := 0; k < len(d.Tracks[track-1].Sectors); k++ {
-		s := d.Tracks[track-1].Sectors[sector]
+	for k := byte(0); k < totalSectors(DirTrack); k++ {
+		s := d.sector(track, sector)
 		for i := 2; i < 0xff; i += 32 {
-			// keep prg files
-			if s.Data[i]&FileIDMask == PrgFileID {
+			// keep all files, not only prg files
+			if !dirSlotIsFree(s, i) {
 				continue
 			}
 			// insert file
@@ -408,81 +787,89 @@ func (d *Disk) addFileToDirectory(firstTrack, firstSector byte, filename string,
 			for j := len(name); j < MaxFilenameSize; j++ {
 				s.Data[i+3+j] = AlternateSpaceCharacter
 			}
+			clearUnusedEntryBytes(s, i)
 			b := SizeToBlocks(prgLength)
 			s.Data[i+28] = byte(b) & 0xff
 			s.Data[i+29] = byte(b >> 8)
-
-			d.Tracks[track-1].Sectors[sector] = s
 			return nil
 		}
 
 		if s.TrackLink() == 0 {
 			break
 		}
-		track = int(s.TrackLink())
-		sector = int(s.SectorLink())
+		if err := sectorIsValid(s.TrackLink(), s.SectorLink()); err != nil {
+			return fmt.Errorf("directory for dir entry %q: %w", name, &ChainError{Track: track, Sector: sector, Reason: "illegal link", Err: err})
+		}
+		track, sector = s.TrackLink(), s.SectorLink()
 	}
 
 	// allocate new dir sector
-	nextTrack, nextSector, err := d.nextFreeSector(byte(track), byte(sector))
+	nextSector, err := d.nextDirSector(sector)
 	if err != nil {
-		return fmt.Errorf("d.nextFreeSector for dir entry %q failed: %w", name, err)
+		return fmt.Errorf("d.nextDirSector for dir entry %q failed: %w", name, err)
 	}
-	d.Tracks[track-1].Sectors[sector].Data[0] = nextTrack
-	d.Tracks[track-1].Sectors[sector].Data[1] = nextSector
+	d.sector(track, sector).SetTrackLink(DirTrack)
+	d.sector(track, sector).SetSectorLink(nextSector)
 
-	track, sector = int(nextTrack), int(nextSector)
-	d.bam[track-1][sector] = true
-	d.Tracks[track-1].Sectors[sector].Data[0] = 0x00
-	d.Tracks[track-1].Sectors[sector].Data[1] = 0xff
+	d.bam[DirTrack-1][nextSector] = true
+	s := d.sector(DirTrack, nextSector)
+	s.zero()
+	s.SetTrackLink(0)
+	s.SetSectorLink(0xff)
 
 	return d.addFileToDirectory(firstTrack, firstSector, filename, prgLength)
 }
 
 // FormatDirectory formats the first directory sector and allocates it in d.bam.
 func (d *Disk) FormatDirectory() {
-	s := Sector{ID: 1}
+	s := d.sector(DirTrack, 1)
+	s.zero()
 	s.SetTrackLink(0)
 	s.SetSectorLink(0xff)
-	d.Tracks[DirTrack-1].Sectors[s.ID] = s
 	d.bam[DirTrack-1][s.ID] = true
 }
 
 // Directory scans the DirTrack and returns all .prg DirEntries.
-func (d Disk) Directory() (dir []DirEntry) {
+func (d *Disk) Directory() (dir []DirEntry) {
 	track, sector := byte(DirTrack), byte(1)
 	for i := byte(0); i < totalSectors(DirTrack); i++ {
-		s := d.Tracks[track-1].Sectors[sector]
+		s := d.sector(track, sector)
 		dir = append(dir, s.directoryEntries()...)
 		if s.TrackLink() == 0 {
 			return dir
 		}
-		track, sector = s.TrackLink(), s.SectorLink()
-		if err := sectorIsValid(track, sector); err != nil {
-			log.Printf("warn: skipping linked directory sector: %v", err)
+		if err := sectorIsValid(s.TrackLink(), s.SectorLink()); err != nil {
+			d.warn(Warning{Track: track, Sector: sector, Message: "skipping linked directory sector", Err: err})
 			return dir
 		}
+		track, sector = s.TrackLink(), s.SectorLink()
 	}
 	return dir
 }
 
 // Validate scans the directory, traces all files including dir, marks their sectors as used and updates the d.bam and the BAM sector.
-func (d *Disk) Validate() {
-	d.bam = [MaxTracks][MaxSectorsForBam]bool{}
-	dirEntries := append(d.Directory(), DirEntry{Track: DirTrack, Sector: 0})
-	for _, dirEntry := range dirEntries {
-		track, sector := dirEntry.Track, dirEntry.Sector
-		for {
-			s := d.Tracks[track-1].Sectors[sector]
-			d.bam[track-1][sector] = true
-			if s.TrackLink() == 0 {
-				break
-			}
-			track, sector = s.TrackLink(), s.SectorLink()
+// Sectors written by WriteRaw on this Disk stay allocated.
+// Returns a *ChainError or *IllegalSectorError for a broken or looping chain, the BAM is left unchanged in that case.
+func (d *Disk) Validate() error {
+	bam := [MaxTracks][MaxSectorsForBam]bool{}
+	for track := range d.raw {
+		for sector, raw := range d.raw[track] {
+			bam[track][sector] = raw
 		}
 	}
+	dirEntries := append(d.Directory(), DirEntry{Track: DirTrack, Sector: 0, Filename: "$"})
+	for _, e := range dirEntries {
+		chain, err := d.chain(e.Track, e.Sector)
+		if err != nil {
+			return fmt.Errorf("file %q: %w", e.Filename, err)
+		}
+		for _, ts := range chain {
+			bam[ts.Track-1][ts.Sector] = true
+		}
+	}
+	d.bam = bam
 	d.setBamEntries()
-	return
+	return nil
 }
 
 // PrintBAMTo prints a human readable representation of d.bam to the io.Writer.
@@ -535,7 +922,7 @@ func (d *Disk) setBamEntries() {
 		bamEntries = append(bamEntries, bamBytes[:]...)
 	}
 	for i, b := range bamEntries {
-		d.Tracks[DirTrack-1].Sectors[0].Data[i+4] = b
+		d.sector(DirTrack, 0).Data[i+4] = b
 	}
 }
 
@@ -552,7 +939,7 @@ func (d *Disk) prepareBam() {
 func (d *Disk) loadBAM() {
 	d.setLabelFromBAM()
 	d.setDiskIDFromBAM()
-	bam := d.Tracks[DirTrack-1].Sectors[0]
+	bam := d.sector(DirTrack, 0)
 	track := byte(0)
 	for i := 4; i < (MaxTracks*4)+4; i += 4 {
 		track++
@@ -569,56 +956,145 @@ func (d *Disk) loadBAM() {
 	}
 }
 
-// freeSector returns the first unallocated sector on the disk.
-// returns error if the disk is full.
-func (d Disk) freeSector() (track, sector byte, err error) {
-	for track = 1; track <= MaxTracks; track++ {
-		if track == DirTrack {
-			continue
-		}
-		for sector = 0; sector < totalSectors(track); sector++ {
-			if d.bam[track-1][sector] == false {
-				return track, sector, nil
+// dirSectors contains the DirTrack sectors in the order they are allocated for the directory on an empty disk.
+var dirSectors = func() (order []byte) {
+	used := [MaxSectors]bool{true}
+	total := totalSectors(DirTrack)
+	for sector := byte(1); len(order) < int(total)-1; {
+		used[sector] = true
+		order = append(order, sector)
+		next := (sector + DirInterleave) % total
+		for i := byte(0); i < total; i++ {
+			if !used[(next+i)%total] {
+				sector = (next + i) % total
+				break
 			}
 		}
 	}
-	return track, sector, fmt.Errorf("freeSector failed: disk full")
+	return order
+}()
+
+// dirReserved returns true if sector is part of the directory reserve on the DirTrack.
+func (d *Disk) dirReserved(sector byte) bool {
+	reserve := byte(DefaultDirReserve)
+	if d.DirReserve != nil {
+		reserve = *d.DirReserve
+	}
+	for i := 0; i < int(reserve) && i < len(dirSectors); i++ {
+		if dirSectors[i] == sector {
+			return true
+		}
+	}
+	return false
 }
 
-// nextFreeSector returns the next unallocated sector, taking SectorInterleave into account.
-// It will skip over the DirTrack, unless you are trying to find the next directory sector.
-func (d Disk) nextFreeSector(currentTrack, currentSector byte) (track, sector byte, err error) {
-	interleave := byte(d.SectorInterleave)
-	dirSector := currentTrack == DirTrack
-	if dirSector {
-		interleave = DirInterleave
+// sectorIsFree returns true if track, sector is unallocated and may be used for file storage.
+func (d *Disk) sectorIsFree(track, sector byte, opt AddOptions) bool {
+	if d.bam[track-1][sector] || !opt.inTrackRange(track) {
+		return false
 	}
-	for track = currentTrack; track <= MaxTracks; track++ {
-		if !dirSector && track == DirTrack {
-			continue
+	if track != DirTrack {
+		return true
+	}
+	return opt.UseDirTrack && !d.dirReserved(sector)
+}
+
+// firstTracks returns the tracks in the order they are searched for the first sector of a file.
+// The DirTrack is only included at the end, if opt allows it.
+func (d *Disk) firstTracks(opt AddOptions) (tracks []byte) {
+	tracks = d.allocation().FirstTracks()
+	if opt.UseDirTrack {
+		tracks = append(tracks, DirTrack)
+	}
+	return tracks
+}
+
+// nextTracks returns the tracks in the order they are searched for the next sector of a file, starting at current.
+// The DirTrack is only included if opt allows it, after all other tracks, unless the file already continues on it.
+func (d *Disk) nextTracks(current byte, opt AddOptions) (tracks []byte) {
+	if opt.UseDirTrack && current == DirTrack {
+		tracks = append(tracks, DirTrack)
+	}
+	tracks = append(tracks, d.allocation().NextTracks(current)...)
+	if opt.UseDirTrack && current != DirTrack {
+		tracks = append(tracks, DirTrack)
+	}
+	return tracks
+}
+
+// freeSector returns the first unallocated sector on the disk, or the pinned sector of opt.
+// returns error if the disk is full or the pinned sector is not available.
+func (d *Disk) freeSector(opt AddOptions) (track, sector byte, err error) {
+	if opt.Track != 0 {
+		if d.bam[opt.Track-1][opt.Sector] {
+			return opt.Track, opt.Sector, fmt.Errorf("track %d sector %d is already allocated", opt.Track, opt.Sector)
 		}
-		currentSector = (currentSector + interleave) % totalSectors(track)
-		for sector = currentSector; sector < totalSectors(track); sector++ {
-			if d.bam[track-1][sector] == false {
+		if !d.sectorIsFree(opt.Track, opt.Sector, opt) {
+			return opt.Track, opt.Sector, fmt.Errorf("track %d sector %d is not available for file storage", opt.Track, opt.Sector)
+		}
+		return opt.Track, opt.Sector, nil
+	}
+	for _, track = range d.firstTracks(opt) {
+		for sector = 0; sector < totalSectors(track); sector++ {
+			if d.sectorIsFree(track, sector, opt) {
 				return track, sector, nil
 			}
 		}
-		for sector = 0; sector < totalSectors(track); sector++ {
-			if d.bam[track-1][sector] == false {
+	}
+	if opt.limited() {
+		return track, sector, fmt.Errorf("freeSector failed: no free sector in track range %d-%d: %w", opt.FirstTrack, opt.LastTrack, ErrDiskFull)
+	}
+	return track, sector, fmt.Errorf("freeSector failed: %w", ErrDiskFull)
+}
+
+// nextFreeSector returns the next unallocated sector, taking the interleave of opt or SectorInterleave into account.
+// It will skip over the DirTrack, unless opt allows storage on the DirTrack.
+func (d *Disk) nextFreeSector(currentTrack, currentSector byte, opt AddOptions) (track, sector byte, err error) {
+	interleave := opt.Interleave
+	if interleave == 0 {
+		interleave = d.SectorInterleave
+	}
+	for _, track = range d.nextTracks(currentTrack, opt) {
+		total := totalSectors(track)
+		currentSector = (currentSector + interleave) % total
+		for i := byte(0); i < total; i++ {
+			sector = (currentSector + i) % total
+			if d.sectorIsFree(track, sector, opt) {
 				return track, sector, nil
 			}
 		}
 	}
-	return track, sector, fmt.Errorf("nextFreeSector failed: disk full")
+	if opt.limited() {
+		return track, sector, fmt.Errorf("nextFreeSector failed: no free sector in track range %d-%d: %w", opt.FirstTrack, opt.LastTrack, ErrDiskFull)
+	}
+	return track, sector, fmt.Errorf("nextFreeSector failed: %w", ErrDiskFull)
+}
+
+// nextDirSector returns the next unallocated sector on the DirTrack for the directory, taking DirInterleave into account.
+func (d *Disk) nextDirSector(currentSector byte) (sector byte, err error) {
+	total := totalSectors(DirTrack)
+	currentSector = (currentSector + DirInterleave) % total
+	for i := byte(0); i < total; i++ {
+		sector = (currentSector + i) % total
+		if !d.bam[DirTrack-1][sector] {
+			return sector, nil
+		}
+	}
+	return sector, fmt.Errorf("nextDirSector failed: %w", ErrDirectoryFull)
 }
 
 // AddFile reads the file at path and adds the file on the disk with filename.
 func (d *Disk) AddFile(path, filename string) error {
+	return d.AddFileWithOptions(path, filename, AddOptions{})
+}
+
+// AddFileWithOptions reads the file at path and adds the file on the disk with filename, customized by opt.
+func (d *Disk) AddFileWithOptions(path, filename string, opt AddOptions) error {
 	prg, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("os.ReadFile %q failed: %w", path, err)
 	}
-	return d.AddPrg(NormalizeFilename(filename), prg)
+	return d.AddPrgWithOptions(NormalizeFilename(filename), prg, opt)
 }
 
 // AddPrgFromReader reads the prg from r and adds the file on the disk with filename.
@@ -632,46 +1108,64 @@ func (d *Disk) AddPrgFromReader(filename string, r io.Reader) error {
 
 // AddPrg adds the prg to the disk with filename.
 func (d *Disk) AddPrg(filename string, prg []byte) error {
+	return d.AddPrgWithOptions(filename, prg, AddOptions{})
+}
+
+// AddPrgWithOptions adds the prg to the disk with filename, customized by opt.
+// Options enabled on the Disk, like UseDirTrack, also apply.
+// The add is transactional: on failure, e.g. when the disk is full, the disk is left unmodified.
+func (d *Disk) AddPrgWithOptions(filename string, prg []byte, opt AddOptions) error {
 	if len(prg) == 0 {
 		return fmt.Errorf("prg file is empty")
 	}
-	track, sector, err := d.freeSector()
+	if err := opt.validate(); err != nil {
+		return fmt.Errorf("invalid options for %q: %w", filename, err)
+	}
+	opt.UseDirTrack = opt.UseDirTrack || d.UseDirTrack
+	return d.Update(func(d *Disk) error {
+		return d.addPrg(filename, prg, opt)
+	})
+}
+
+// addPrg adds the prg to the disk with filename, customized by opt.
+// On failure the disk is left in an inconsistent state, callers need to restore it.
+func (d *Disk) addPrg(filename string, prg []byte, opt AddOptions) error {
+	track, sector, err := d.freeSector(opt)
 	if err != nil {
 		return fmt.Errorf("d.freeSector failed: %w", err)
 	}
+	// allocate the first sector before the directory may need a new sector on the DirTrack
+	d.bam[track-1][sector] = true
 	if err = d.addFileToDirectory(track, sector, filename, len(prg)); err != nil {
 		return fmt.Errorf("d.addFileToDirectory %q failed: %w", filename, err)
 	}
 
-	buf := make([]byte, len(prg), len(prg))
-	copy(buf, prg)
-
+	buf := prg
 	// drain buffer with writing full sectors
 	for len(buf) > BlockSize {
 		d.bam[track-1][sector] = true
 		var sectorContent []byte
 		sectorContent, buf = buf[0:BlockSize], buf[BlockSize:]
-		nextTrack, nextSector, err := d.nextFreeSector(track, sector)
+		nextTrack, nextSector, err := d.nextFreeSector(track, sector, opt)
 		if err != nil {
 			return fmt.Errorf("d.nextFreeSector track %d sector %d failed: %w", track, sector, err)
 		}
 
-		d.Tracks[track-1].Sectors[sector].Data[0] = nextTrack
-		d.Tracks[track-1].Sectors[sector].Data[1] = nextSector
-		for i, v := range sectorContent {
-			d.Tracks[track-1].Sectors[sector].Data[2+i] = v
-		}
+		s := d.sector(track, sector)
+		s.SetTrackLink(nextTrack)
+		s.SetSectorLink(nextSector)
+		copy(s.Data[2:], sectorContent)
 		track, sector = nextTrack, nextSector
 	}
 
 	if len(buf) > 0 {
 		// write partial last sector
 		d.bam[track-1][sector] = true
-		d.Tracks[track-1].Sectors[sector].Data[0] = 0
-		d.Tracks[track-1].Sectors[sector].Data[1] = byte(len(buf) + 1)
-		for i, v := range buf {
-			d.Tracks[track-1].Sectors[sector].Data[2+i] = v
-		}
+		s := d.sector(track, sector)
+		s.zero()
+		s.SetTrackLink(0)
+		s.SetSectorLink(byte(len(buf) + 1))
+		copy(s.Data[2:], buf)
 	}
 
 	d.setBamEntries()
@@ -698,6 +1192,10 @@ func NormalizeFilename(f string) string {
 }
 
 // SizeToBlocks returns the amount of blocks (sectors) used for a file of specified size.
+// Each block holds BlockSize bytes, an empty file still uses a single block.
 func SizeToBlocks(size int) (b int) {
-	return int(size/BlockSize) + 1
+	if size <= BlockSize {
+		return 1
+	}
+	return (size + BlockSize - 1) / BlockSize
 }
@@ -2,9 +2,12 @@ package d64
 
 import (
 	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -180,6 +183,21 @@ func TestTrackSectorToDataOffset(t *testing.T) {
 	}
 }
 
+func TestDiskString(t *testing.T) {
+	d := NewDisk("string", "01 2a", DefaultSectorInterleave)
+	if err := d.AddFile(testPrg1, "one"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	// a directory entry claiming more blocks than the disk has
+	slot := d.findSlots("one")[0]
+	s := d.sector(slot.dir.Track, slot.dir.Sector)
+	s.Data[slot.offset+28], s.Data[slot.offset+29] = 0xff, 0xff
+	want := fmt.Sprintf("%3d blocks free\n", d.BlocksFree())
+	if got := d.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("d.String() == %q, want suffix %q", got, want)
+	}
+}
+
 func TestDiskValidate(t *testing.T) {
 	out, err := ioutil.TempFile("", "testdiskvalidate.*.d64")
 	if err != nil {
@@ -194,7 +212,9 @@ func TestDiskValidate(t *testing.T) {
 	}
 
 	d.FormatBAM()
-	d.Validate()
+	if err = d.Validate(); err != nil {
+		t.Fatalf("d.Validate failed: %v", err)
+	}
 	dirEntries := d.Directory()
 	for i := range testFileBlocks {
 		if dirEntries[i].BlockSize != testFileBlocks[i] {
@@ -399,7 +419,10 @@ func TestSizeToBlocks(t *testing.T) {
 	}{
 		{0, 1},
 		{253, 1},
-		{254, 2},
+		{254, 1},
+		{255, 2},
+		{508, 2},
+		{509, 3},
 		{500, 2},
 		{512, 3},
 		{4096, 17},
@@ -418,3 +441,282 @@ func BenchmarkSizeToBlocks(b *testing.B) {
 		SizeToBlocks(i)
 	}
 }
+
+func TestDirSectors(t *testing.T) {
+	want := []byte{1, 4, 7, 10, 13, 16, 2, 5, 8, 11, 14, 17, 3, 6, 9, 12, 15, 18}
+	if !bytes.Equal(dirSectors, want) {
+		t.Errorf("dirSectors mismatch, got %v want %v", dirSectors, want)
+	}
+}
+
+func TestUseDirTrack(t *testing.T) {
+	const blocks = MaxBlocks + 10
+	prg := bytes.Repeat([]byte{0xea}, blocks*BlockSize-1)
+
+	d := NewDisk("no dirtrack", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrg("toolarge", prg); err == nil {
+		t.Errorf("d.AddPrg %d blocks without UseDirTrack should have failed", blocks)
+	}
+
+	d = NewDisk("dirtrack", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrgWithOptions("dirtrack", prg, AddOptions{UseDirTrack: true}); err != nil {
+		t.Fatalf("d.AddPrgWithOptions with UseDirTrack failed: %v", err)
+	}
+	for i := 0; i < DefaultDirReserve; i++ {
+		if dirSectors[i] != 1 && d.bam[DirTrack-1][dirSectors[i]] {
+			t.Errorf("reserved directory sector %d is allocated", dirSectors[i])
+		}
+	}
+	e := d.Directory()[0]
+	got, err := d.Extract(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.Extract(%d, %d) failed: %v", e.Track, e.Sector, err)
+	}
+	if !bytes.Equal(got, prg) {
+		t.Errorf("d.Extract(%d, %d) content mismatch", e.Track, e.Sector)
+	}
+
+	d = NewDisk("dirtrack", "01 2a", DefaultSectorInterleave)
+	d.UseDirTrack = true
+	reserve := byte(18)
+	d.DirReserve = &reserve
+	if err := d.AddPrg("toolarge", prg); err == nil {
+		t.Errorf("d.AddPrg %d blocks with full DirReserve should have failed", blocks)
+	}
+
+	d = NewDisk("dirtrack", "01 2a", DefaultSectorInterleave)
+	d.UseDirTrack = true
+	reserve = 0
+	d.DirReserve = &reserve
+	if err := d.AddPrg("dirtrack", prg); err != nil {
+		t.Fatalf("d.AddPrg with zero DirReserve failed: %v", err)
+	}
+	for _, sector := range dirSectors[:DefaultDirReserve] {
+		if !d.bam[DirTrack-1][sector] {
+			t.Errorf("directory sector %d is free with zero DirReserve", sector)
+		}
+	}
+}
+
+func TestUseDirTrackFull(t *testing.T) {
+	zero := byte(0)
+	for _, reserve := range []*byte{&zero, nil} {
+		d := NewDisk("dirtrack", "01 2a", DefaultSectorInterleave)
+		d.UseDirTrack, d.DirReserve = true, reserve
+		for track := byte(1); track <= MaxTracks; track++ {
+			for sector := byte(0); sector < totalSectors(track); sector++ {
+				if track != DirTrack {
+					d.bam[track-1][sector] = true
+				}
+			}
+		}
+		d.setBamEntries()
+
+		var added []string
+		for n := 0; n < MaxSectors; n++ {
+			name := "f" + strconv.Itoa(n)
+			if err := d.AddPrg(name, []byte{0x01, 0x08, byte(n)}); err != nil {
+				break
+			}
+			added = append(added, name)
+		}
+		if len(added) <= dirEntriesPerSector {
+			t.Fatalf("only %d files added, want more than %d to need a new directory sector", len(added), dirEntriesPerSector)
+		}
+		if got := len(d.Directory()); got != len(added) {
+			t.Errorf("directory has %d files, want %d", got, len(added))
+		}
+		for n, name := range added {
+			prg, err := d.ReadFile(name)
+			if err != nil || !bytes.Equal(prg, []byte{0x01, 0x08, byte(n)}) {
+				t.Errorf("d.ReadFile %q == % x, %v", name, prg, err)
+			}
+		}
+		if err := d.Validate(); err != nil {
+			t.Errorf("d.Validate failed: %v", err)
+		}
+	}
+}
+
+func TestFileInterleave(t *testing.T) {
+	d := NewDisk("interleave", "01 2a", DefaultSectorInterleave)
+	prg := bytes.Repeat([]byte{0xea}, 20*BlockSize)
+	interleaves := []byte{0, 4, 1, 7}
+	for n, interleave := range interleaves {
+		if err := d.AddPrgWithOptions("file "+strconv.Itoa(n), prg, AddOptions{Interleave: interleave}); err != nil {
+			t.Fatalf("d.AddPrgWithOptions interleave %d failed: %v", interleave, err)
+		}
+	}
+	for n, e := range d.Directory() {
+		want := interleaves[n]
+		if want == 0 {
+			want = DefaultSectorInterleave
+		}
+		got, err := d.FileInterleave(e)
+		if err != nil {
+			t.Fatalf("d.FileInterleave %q failed: %v", e.Filename, err)
+		}
+		if got != want {
+			t.Errorf("d.FileInterleave %q got %d want %d", e.Filename, got, want)
+		}
+		prg2, err := d.Extract(e.Track, e.Sector)
+		if err != nil {
+			t.Fatalf("d.Extract(%d, %d) failed: %v", e.Track, e.Sector, err)
+		}
+		if !bytes.Equal(prg, prg2) {
+			t.Errorf("d.Extract(%d, %d) content mismatch", e.Track, e.Sector)
+		}
+	}
+}
+
+func TestWriteFileWithBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testwritefilebackups")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := dir + "/backups.d64"
+	labels := []string{"first", "second", "third", "fourth"}
+	for _, label := range labels {
+		d := NewDisk(label, "01 2a", DefaultSectorInterleave)
+		if err = d.WriteFileWithBackups(path, 2); err != nil {
+			t.Fatalf("d.WriteFileWithBackups %q failed: %v", path, err)
+		}
+	}
+	for p, want := range map[string]string{path: "fourth", path + ".bak": "third", path + ".bak.2": "second"} {
+		d, err := LoadDisk(p)
+		if err != nil {
+			t.Fatalf("LoadDisk %q error: %v", p, err)
+		}
+		if d.Label != want {
+			t.Errorf("LoadDisk %q label got %q want %q", p, d.Label, want)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ioutil.ReadDir %q failed: %v", dir, err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("directory contains %d files, want %d", len(entries), 3)
+	}
+	for _, e := range entries {
+		if e.Size() != defaultD64Size {
+			t.Errorf("file %q has size %d, want %d", e.Name(), e.Size(), defaultD64Size)
+		}
+	}
+
+	if err = NewDisk("fail", "01 2a", DefaultSectorInterleave).WriteFile(dir + "/nonexisting/fail.d64"); err == nil {
+		t.Errorf("d.WriteFile to non-existing directory should have failed")
+	}
+}
+
+func BenchmarkLoadDisk(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadDisk(testD64); err != nil {
+			b.Fatalf("LoadDisk %q error: %v", testD64, err)
+		}
+	}
+}
+
+func BenchmarkAddPrg(b *testing.B) {
+	prg, err := os.ReadFile(testLongPrg)
+	if err != nil {
+		b.Fatalf("os.ReadFile %q failed: %v", testLongPrg, err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := NewDisk("bench", "01 2a", DefaultSectorInterleave)
+		if err = d.AddPrg("enforcer", prg); err != nil {
+			b.Fatalf("d.AddPrg failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		b.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	dir := d.Directory()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range dir {
+			if _, err = d.Extract(e.Track, e.Sector); err != nil {
+				b.Fatalf("d.Extract(%d, %d) failed: %v", e.Track, e.Sector, err)
+			}
+		}
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		b.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = d.WriteTo(io.Discard); err != nil {
+			b.Fatalf("d.WriteTo failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkDirectory(b *testing.B) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		b.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d.Directory()
+	}
+}
+
+func TestChain(t *testing.T) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	for n, e := range d.Directory() {
+		chain, err := d.Chain(e)
+		if err != nil {
+			t.Fatalf("d.Chain %q failed: %v", e.Filename, err)
+		}
+		if len(chain) != testFileBlocks[n] {
+			t.Errorf("d.Chain %q has %d sectors, want %d", e.Filename, len(chain), testFileBlocks[n])
+		}
+		if chain[0].Track != e.Track || chain[0].Sector != e.Sector {
+			t.Errorf("d.Chain %q starts on track %d sector %d, want %d, %d", e.Filename, chain[0].Track, chain[0].Sector, e.Track, e.Sector)
+		}
+		size := 0
+		for _, cs := range chain {
+			size += cs.Bytes
+		}
+		if size != testFileLength[n] {
+			t.Errorf("d.Chain %q holds %d bytes, want %d", e.Filename, size, testFileLength[n])
+		}
+	}
+}
+
+func TestZeroSector(t *testing.T) {
+	var s Sector
+	if s.TrackLink() != 0 || s.SectorLink() != 0 || s.Bytes() != nil {
+		t.Errorf("zero Sector links %d,%d bytes %v, want 0,0 nil", s.TrackLink(), s.SectorLink(), s.Bytes())
+	}
+	s.SetTrackLink(18)
+	s.SetSectorLink(1)
+	if len(s.Data) != SectorSize || s.TrackLink() != 18 || s.SectorLink() != 1 {
+		t.Errorf("zero Sector after SetTrackLink: %d bytes, links %d,%d", len(s.Data), s.TrackLink(), s.SectorLink())
+	}
+}
+
+func TestLoadDiskCopies(t *testing.T) {
+	d, err := LoadDisk(validatedD64)
+	if err != nil {
+		t.Fatalf("LoadDisk failed: %v", err)
+	}
+	if cap(d.data) != ImageSize {
+		t.Errorf("image data capacity == %d, want %d", cap(d.data), ImageSize)
+	}
+}
@@ -0,0 +1,206 @@
+package d64
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// device is the block-device backend of a Disk opened by OpenDevice.
+// Sectors are read on first access and only modified sectors are written by Flush.
+// mu guards the lazy loading, so read-only Disk methods may be called concurrently.
+type device struct {
+	mu     sync.Mutex
+	r      io.ReaderAt
+	w      io.WriterAt
+	closer io.Closer
+	loaded [MaxTracks][MaxSectors]bool
+	// orig holds the image data of the loaded sectors as it is on the device.
+	orig []byte
+	err  error
+}
+
+// OpenDevice returns a *Disk backed by r, sectors are read from r on first access.
+// If r also implements io.WriterAt, Flush writes modified sectors back to r.
+// If r implements io.Closer, Close closes r.
+func OpenDevice(r io.ReaderAt) (*Disk, error) {
+	d := &Disk{SectorInterleave: DefaultSectorInterleave}
+	d.dev = &device{r: r, orig: make([]byte, ImageSize)}
+	if w, ok := r.(io.WriterAt); ok {
+		d.dev.w = w
+	}
+	if c, ok := r.(io.Closer); ok {
+		d.dev.closer = c
+	}
+	d.setData(make([]byte, ImageSize))
+
+	d.loadBAM()
+	if d.dev.err != nil {
+		return d, fmt.Errorf("reading BAM failed: %w", d.dev.err)
+	}
+	d.guessInterleave()
+	if d.dev.err != nil {
+		return d, fmt.Errorf("reading directory failed: %w", d.dev.err)
+	}
+	return d, nil
+}
+
+// OpenImage opens the .d64 image at path for in-place sector I/O.
+// The image is opened read-write if permitted, otherwise read-only.
+// Call Close when done, to write all modified sectors and close the file.
+func OpenImage(path string) (*Disk, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if f, err = os.Open(path); err != nil {
+			return nil, fmt.Errorf("os.Open %q failed: %w", path, err)
+		}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("f.Stat %q failed: %w", path, err)
+	}
+	if fi.Size() < ImageSize {
+		f.Close()
+		return nil, fmt.Errorf("file %q is too small for a .d64, %d bytes: %w", path, fi.Size(), ErrInvalidImage)
+	}
+	var r io.ReaderAt = f
+	if !writable(f) {
+		r = readOnlyFile{f}
+	}
+	d, err := OpenDevice(r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("OpenDevice %q failed: %w", path, err)
+	}
+	return d, nil
+}
+
+// writable returns true if f was opened for writing.
+func writable(f *os.File) bool {
+	_, err := f.WriteAt(nil, 0)
+	return err == nil
+}
+
+// readOnlyFile hides the WriteAt method of a read-only *os.File.
+type readOnlyFile struct {
+	f *os.File
+}
+
+func (r readOnlyFile) ReadAt(p []byte, off int64) (int, error) {
+	return r.f.ReadAt(p, off)
+}
+
+func (r readOnlyFile) Close() error {
+	return r.f.Close()
+}
+
+// sector returns the sector at track, sector, reading it from the device first if needed.
+// Read errors are recorded and returned by Err, the sector is zero-filled in that case.
+func (d *Disk) sector(track, sector byte) *Sector {
+	s := &d.Tracks[track-1].Sectors[sector]
+	if d.dev == nil {
+		return s
+	}
+	d.dev.mu.Lock()
+	defer d.dev.mu.Unlock()
+	if d.dev.loaded[track-1][sector] {
+		return s
+	}
+	d.dev.loaded[track-1][sector] = true
+	offset := trackSectorToDataOffset(track, sector)
+	s.zero()
+	if _, err := d.dev.r.ReadAt(s.Data, int64(offset)); err != nil && d.dev.err == nil {
+		d.dev.err = fmt.Errorf("ReadAt track %d sector %d failed: %w", track, sector, err)
+	}
+	copy(d.dev.orig[offset:offset+SectorSize], s.Data)
+	return s
+}
+
+// Err returns the first read error of a disk opened by OpenDevice or OpenImage.
+func (d *Disk) Err() error {
+	if d.dev == nil {
+		return nil
+	}
+	d.dev.mu.Lock()
+	defer d.dev.mu.Unlock()
+	return d.dev.err
+}
+
+// LoadAll reads all sectors not yet read from the device.
+func (d *Disk) LoadAll() error {
+	if d.dev == nil {
+		return nil
+	}
+	for track := byte(1); track <= MaxTracks; track++ {
+		for sector := byte(0); sector < totalSectors(track); sector++ {
+			d.sector(track, sector)
+		}
+	}
+	return d.Err()
+}
+
+// Dirty returns all sectors modified since they were read from, or last flushed to, the device.
+func (d *Disk) Dirty() (sectors []TrackSector) {
+	if d.dev == nil {
+		return nil
+	}
+	d.dev.mu.Lock()
+	defer d.dev.mu.Unlock()
+	for track := byte(1); track <= MaxTracks; track++ {
+		for sector := byte(0); sector < totalSectors(track); sector++ {
+			if !d.dev.loaded[track-1][sector] {
+				continue
+			}
+			offset := trackSectorToDataOffset(track, sector)
+			if !bytes.Equal(d.data[offset:offset+SectorSize], d.dev.orig[offset:offset+SectorSize]) {
+				sectors = append(sectors, TrackSector{track, sector})
+			}
+		}
+	}
+	return sectors
+}
+
+// Flush writes all modified sectors to the device.
+func (d *Disk) Flush() error {
+	if d.dev == nil {
+		return fmt.Errorf("disk is not opened on a device")
+	}
+	if err := d.Err(); err != nil {
+		return fmt.Errorf("refusing to flush after read error: %w", err)
+	}
+	dirty := d.Dirty()
+	if len(dirty) > 0 && d.dev.w == nil {
+		return fmt.Errorf("device is read-only, %d sectors modified", len(dirty))
+	}
+	for _, ts := range dirty {
+		offset := trackSectorToDataOffset(ts.Track, ts.Sector)
+		data := d.data[offset : offset+SectorSize]
+		if _, err := d.dev.w.WriteAt(data, int64(offset)); err != nil {
+			return fmt.Errorf("WriteAt track %d sector %d failed: %w", ts.Track, ts.Sector, err)
+		}
+		copy(d.dev.orig[offset:offset+SectorSize], data)
+	}
+	if f, ok := d.dev.w.(*os.File); ok && len(dirty) > 0 {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("f.Sync failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes all modified sectors and closes the device.
+func (d *Disk) Close() error {
+	if d.dev == nil {
+		return nil
+	}
+	err := d.Flush()
+	if d.dev.closer != nil {
+		if cerr := d.dev.closer.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Close failed: %w", cerr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package d64
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memDevice is an in-memory io.ReaderAt and io.WriterAt counting its calls.
+type memDevice struct {
+	buf    []byte
+	reads  int
+	writes int
+}
+
+func (m *memDevice) ReadAt(p []byte, off int64) (int, error) {
+	m.reads++
+	return copy(p, m.buf[off:]), nil
+}
+
+func (m *memDevice) WriteAt(p []byte, off int64) (int, error) {
+	m.writes++
+	return copy(m.buf[off:], p), nil
+}
+
+func TestOpenDevice(t *testing.T) {
+	bin, err := os.ReadFile(validatedD64)
+	if err != nil {
+		t.Fatalf("os.ReadFile %q failed: %v", validatedD64, err)
+	}
+	dev := &memDevice{buf: append([]byte{}, bin...)}
+	d, err := OpenDevice(dev)
+	if err != nil {
+		t.Fatalf("OpenDevice failed: %v", err)
+	}
+	want, err := LoadDisk(validatedD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q failed: %v", validatedD64, err)
+	}
+	if d.Label != want.Label || d.DiskID != want.DiskID || d.BlocksFree() != want.BlocksFree() {
+		t.Errorf("OpenDevice and LoadDisk differ: %q %q %d, want %q %q %d", d.Label, d.DiskID, d.BlocksFree(), want.Label, want.DiskID, want.BlocksFree())
+	}
+	if dev.reads >= MaxBlocks {
+		t.Errorf("OpenDevice read %d sectors, expected lazy loading", dev.reads)
+	}
+
+	if err = d.AddFile(testPrg1, "patch"); err != nil {
+		t.Fatalf("d.AddFile failed: %v", err)
+	}
+	if err = want.AddFile(testPrg1, "patch"); err != nil {
+		t.Fatalf("want.AddFile failed: %v", err)
+	}
+	dirty := len(d.Dirty())
+	if err = d.Flush(); err != nil {
+		t.Fatalf("d.Flush failed: %v", err)
+	}
+	if dev.writes != dirty {
+		t.Errorf("d.Flush wrote %d sectors, want %d", dev.writes, dirty)
+	}
+	if len(d.Dirty()) != 0 {
+		t.Errorf("d.Dirty after d.Flush: %v", d.Dirty())
+	}
+	buf := &bytes.Buffer{}
+	if _, err = want.WriteTo(buf); err != nil {
+		t.Fatalf("want.WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(dev.buf, buf.Bytes()) {
+		t.Errorf("device content differs from LoadDisk, AddFile and WriteTo")
+	}
+}
+
+func TestDeviceRollback(t *testing.T) {
+	bin, err := os.ReadFile(validatedD64)
+	if err != nil {
+		t.Fatalf("os.ReadFile %q failed: %v", validatedD64, err)
+	}
+	dev := &memDevice{buf: append([]byte{}, bin...)}
+	d, err := OpenDevice(dev)
+	if err != nil {
+		t.Fatalf("OpenDevice failed: %v", err)
+	}
+	tx := d.Begin()
+	if err = d.AddFile(testPrg1, "patch"); err != nil {
+		t.Fatalf("d.AddFile failed: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("tx.Rollback failed: %v", err)
+	}
+	if n := len(d.Dirty()); n != 0 {
+		t.Errorf("%d dirty sectors after tx.Rollback", n)
+	}
+	if err = d.Flush(); err != nil || dev.writes != 0 {
+		t.Errorf("d.Flush after tx.Rollback wrote %d sectors, err: %v", dev.writes, err)
+	}
+}
+
+func TestOpenImage(t *testing.T) {
+	bin, err := os.ReadFile(validatedD64)
+	if err != nil {
+		t.Fatalf("os.ReadFile %q failed: %v", validatedD64, err)
+	}
+	path := filepath.Join(t.TempDir(), "image.d64")
+	if err = os.WriteFile(path, bin, 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	d, err := OpenImage(path)
+	if err != nil {
+		t.Fatalf("OpenImage %q failed: %v", path, err)
+	}
+	if err = d.Rename(d.Directory()[0].Filename, "renamed"); err != nil {
+		t.Fatalf("d.Rename failed: %v", err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatalf("d.Close failed: %v", err)
+	}
+	d, err = LoadDisk(path)
+	if err != nil {
+		t.Fatalf("LoadDisk %q failed: %v", path, err)
+	}
+	if got := d.Directory()[0].Filename; got != "renamed" {
+		t.Errorf("first file is %q, want %q", got, "renamed")
+	}
+
+	if err = os.WriteFile(path, bin[:1000], 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	if _, err = OpenImage(path); err == nil {
+		t.Errorf("OpenImage of a truncated image should have failed")
+	}
+}
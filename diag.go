@@ -0,0 +1,70 @@
+package d64
+
+import "fmt"
+
+// A Warning is a non-fatal problem found on the disk, e.g. a broken sector chain that was skipped.
+type Warning struct {
+	// Track and Sector locate the problem, zero Track means the problem is not related to a single sector.
+	Track  byte
+	Sector byte
+	// Filename is the file affected by the problem, if any.
+	Filename string
+	Message  string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (w Warning) String() string {
+	s := w.Message
+	if w.Filename != "" {
+		s = fmt.Sprintf("file %q: %s", w.Filename, s)
+	}
+	if w.Track != 0 {
+		s = fmt.Sprintf("track %d sector %d: %s", w.Track, w.Sector, s)
+	}
+	if w.Err != nil {
+		s += ": " + w.Err.Error()
+	}
+	return s
+}
+
+// warn collects w and passes it to d.WarningHandler, if set.
+// A warning identical to an already collected one is ignored, so repeated reads do not grow the list.
+func (d *Disk) warn(w Warning) {
+	if !d.collect(w) {
+		return
+	}
+	if handler := d.WarningHandler; handler != nil {
+		handler(w)
+	}
+}
+
+// collect adds w to the collected warnings, returns false if an identical warning was already collected.
+func (d *Disk) collect(w Warning) bool {
+	d.warningsMu.Lock()
+	defer d.warningsMu.Unlock()
+	key := w.String()
+	if d.warningKeys[key] {
+		return false
+	}
+	if d.warningKeys == nil {
+		d.warningKeys = map[string]bool{}
+	}
+	d.warningKeys[key] = true
+	d.warnings = append(d.warnings, w)
+	return true
+}
+
+// Warnings returns all warnings collected since the disk was loaded or ClearWarnings was called.
+func (d *Disk) Warnings() []Warning {
+	d.warningsMu.Lock()
+	defer d.warningsMu.Unlock()
+	return append([]Warning(nil), d.warnings...)
+}
+
+// ClearWarnings discards all collected warnings.
+func (d *Disk) ClearWarnings() {
+	d.warningsMu.Lock()
+	defer d.warningsMu.Unlock()
+	d.warnings, d.warningKeys = nil, nil
+}
@@ -0,0 +1,57 @@
+package d64
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	d := NewDisk("warnings", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrg("one", make([]byte, 3*BlockSize)); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	if err := d.AddPrg("two", make([]byte, 3*BlockSize)); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	var handled []Warning
+	d.WarningHandler = func(w Warning) {
+		handled = append(handled, w)
+	}
+
+	e := d.Directory()[1]
+	d.sector(e.Track, e.Sector).SetTrackLink(MaxTracks + 1)
+	for i := 0; i < 2; i++ {
+		paths, err := d.ExtractToPath(t.TempDir())
+		if err != nil {
+			t.Fatalf("d.ExtractToPath failed: %v", err)
+		}
+		if len(paths) != 1 {
+			t.Errorf("d.ExtractToPath extracted %d files, want 1", len(paths))
+		}
+	}
+	warnings := d.Warnings()
+	if len(warnings) != 1 || len(handled) != 1 {
+		t.Fatalf("got %d warnings and %d handled, want 1: %v", len(warnings), len(handled), warnings)
+	}
+	w := warnings[0]
+	var ce *ChainError
+	if w.Filename != e.Filename || w.Track != e.Track || w.Sector != e.Sector || !errors.As(w.Err, &ce) {
+		t.Errorf("unexpected warning: %s", w)
+	}
+
+	d.ClearWarnings()
+	d.sector(DirTrack, 1).SetTrackLink(MaxTracks + 1)
+	if n := len(d.Directory()); n != 2 {
+		t.Errorf("d.Directory returned %d files, want 2", n)
+	}
+	warnings = d.Warnings()
+	if len(warnings) != 1 || warnings[0].Track != DirTrack || warnings[0].Sector != 1 {
+		t.Errorf("unexpected directory warnings: %v", warnings)
+	}
+
+	c := d.Clone()
+	c.Directory()
+	if n := len(c.Warnings()); n != 1 {
+		t.Errorf("clone has %d warnings after a repeated read, want 1", n)
+	}
+}
@@ -0,0 +1,575 @@
+package d64
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DOS status codes, as reported by the 1541 on the command channel.
+const (
+	StatusOK              = 0
+	StatusFilesScratched  = 1
+	StatusReadError       = 20
+	StatusSyntaxError     = 30
+	StatusInvalidCommand  = 31
+	StatusInvalidFilename = 33
+	StatusNoFilename      = 34
+	StatusFileNotOpen     = 61
+	StatusFileNotFound    = 62
+	StatusFileExists      = 63
+	StatusTypeMismatch    = 64
+	StatusNoBlock         = 65
+	StatusIllegalSector   = 66
+	StatusNoChannel       = 70
+	StatusDiskFull        = 72
+	StatusDOSVersion      = 73
+	firstDataChannel      = 2
+	lastDataChannel       = 14
+	commandChannel        = 15
+	dosVersionDescription = "CBM DOS V2.6 1541"
+)
+
+// statusMessages holds the 1541 message of each status code.
+// The leading space of OK and FILES SCRATCHED matches the original DOS.
+var statusMessages = map[int]string{
+	StatusOK:              " OK",
+	StatusFilesScratched:  " FILES SCRATCHED",
+	StatusReadError:       "READ ERROR",
+	StatusSyntaxError:     "SYNTAX ERROR",
+	StatusInvalidCommand:  "SYNTAX ERROR",
+	StatusInvalidFilename: "SYNTAX ERROR",
+	StatusNoFilename:      "SYNTAX ERROR",
+	StatusFileNotOpen:     "FILE NOT OPEN",
+	StatusFileNotFound:    "FILE NOT FOUND",
+	StatusFileExists:      "FILE EXISTS",
+	StatusTypeMismatch:    "FILE TYPE MISMATCH",
+	StatusNoBlock:         "NO BLOCK",
+	StatusIllegalSector:   "ILLEGAL TRACK OR SECTOR",
+	StatusNoChannel:       "NO CHANNEL",
+	StatusDiskFull:        "DISK FULL",
+	StatusDOSVersion:      dosVersionDescription,
+}
+
+// A Status is the result of a DOS command, as read from the command channel.
+type Status struct {
+	Code   int
+	Track  byte
+	Sector byte
+}
+
+// String returns the status in 1541 format, e.g. "00, OK,00,00" or "62,FILE NOT FOUND,00,00".
+func (s Status) String() string {
+	return fmt.Sprintf("%02d,%s,%02d,%02d", s.Code, statusMessages[s.Code], s.Track, s.Sector)
+}
+
+// OK returns true if the status does not report an error.
+func (s Status) OK() bool {
+	return s.Code < StatusReadError || s.Code == StatusDOSVersion
+}
+
+// A Drive interprets 1541 DOS commands, as sent over the command channel, on a Disk.
+// It also provides the file channels 0-14, to open, read and write named files or sector buffers.
+// A Drive is not safe for concurrent use.
+type Drive struct {
+	Disk     *Disk
+	status   Status
+	buffers  map[int][]byte
+	channels map[int]*driveChannel
+	modified bool
+}
+
+// A driveChannel is an open file or sector buffer.
+type driveChannel struct {
+	name    string
+	data    []byte
+	pos     int
+	write   bool
+	replace bool
+	buffer  bool
+}
+
+// NewDrive returns a Drive for d, with the power-on status "73,CBM DOS V2.6 1541,00,00".
+func NewDrive(d *Disk) *Drive {
+	return &Drive{
+		Disk:     d,
+		status:   Status{Code: StatusDOSVersion},
+		buffers:  map[int][]byte{},
+		channels: map[int]*driveChannel{},
+	}
+}
+
+// Status returns the current status and resets it to OK, like reading the command channel does.
+func (dr *Drive) Status() Status {
+	s := dr.status
+	dr.status = Status{}
+	return s
+}
+
+// Modified returns true if the Disk was modified through the drive since NewDrive or the last ResetModified.
+func (dr *Drive) Modified() bool {
+	return dr.modified
+}
+
+// ResetModified clears the modified state, e.g. after writing the Disk.
+func (dr *Drive) ResetModified() {
+	dr.modified = false
+}
+
+// modify marks the Disk as modified if status does not report an error, and returns status.
+func (dr *Drive) modify(status Status) Status {
+	if status.OK() {
+		dr.modified = true
+	}
+	return status
+}
+
+// Buffer returns the SectorSize buffer of data channel 2-14, used by the block commands U1 and U2.
+// Returns nil for other channels.
+func (dr *Drive) Buffer(channel int) []byte {
+	if channel < firstDataChannel || channel > lastDataChannel {
+		return nil
+	}
+	if dr.buffers[channel] == nil {
+		dr.buffers[channel] = make([]byte, SectorSize)
+	}
+	return dr.buffers[channel]
+}
+
+// Exec executes a DOS command and returns the resulting status, which is also returned by the next call to Status.
+//
+// Supported commands are S (scratch), R (rename), C (copy), N (new), V (validate), I (initialize),
+// B-A and B-F (block allocate and free), U1 or B-R and U2 or B-W (block read and write to a channel buffer) and UJ (reset).
+// The drive number, e.g. the 0 in "S0:NAME", is optional and ignored.
+func (dr *Drive) Exec(cmd string) Status {
+	cmd = strings.TrimRight(cmd, "\r\n")
+	dr.status = dr.exec(cmd)
+	return dr.status
+}
+
+func (dr *Drive) exec(cmd string) Status {
+	switch {
+	case cmd == "":
+		return Status{}
+	case hasPrefixFold(cmd, "B-A"):
+		return dr.blockAllocate(cmd[3:], true)
+	case hasPrefixFold(cmd, "B-F"):
+		return dr.blockAllocate(cmd[3:], false)
+	case hasPrefixFold(cmd, "B-R"):
+		return dr.blockReadWrite(cmd[3:], true)
+	case hasPrefixFold(cmd, "B-W"):
+		return dr.blockReadWrite(cmd[3:], false)
+	case hasPrefixFold(cmd, "U1"), hasPrefixFold(cmd, "UA"):
+		return dr.blockReadWrite(cmd[2:], true)
+	case hasPrefixFold(cmd, "U2"), hasPrefixFold(cmd, "UB"):
+		return dr.blockReadWrite(cmd[2:], false)
+	case hasPrefixFold(cmd, "UJ"), hasPrefixFold(cmd, "U:"), hasPrefixFold(cmd, "UI"):
+		dr.buffers = map[int][]byte{}
+		dr.channels = map[int]*driveChannel{}
+		dr.Disk.loadBAM()
+		return Status{Code: StatusDOSVersion}
+	}
+
+	args := dosArgs(cmd)
+	switch cmd[0] | 0x20 {
+	case 's':
+		return dr.scratch(args)
+	case 'r':
+		return dr.rename(args)
+	case 'c':
+		return dr.copy(args)
+	case 'n':
+		return dr.format(args)
+	case 'v':
+		return dr.modify(statusFromError(dr.Disk.Validate()))
+	case 'i':
+		dr.Disk.loadBAM()
+		return Status{}
+	}
+	return Status{Code: StatusInvalidCommand}
+}
+
+// hasPrefixFold returns true if cmd starts with the ASCII prefix, ignoring case.
+func hasPrefixFold(cmd, prefix string) bool {
+	return len(cmd) >= len(prefix) && strings.EqualFold(cmd[:len(prefix)], prefix)
+}
+
+// dosArgs returns the arguments after the colon in cmd.
+func dosArgs(cmd string) string {
+	i := strings.IndexByte(cmd, ':')
+	if i < 0 {
+		return ""
+	}
+	return cmd[i+1:]
+}
+
+// statusFromError maps errors of Disk operations to the matching 1541 status.
+func statusFromError(err error) Status {
+	var ise *IllegalSectorError
+	var ce *ChainError
+	switch {
+	case err == nil:
+		return Status{}
+	case errors.Is(err, ErrFileNotFound):
+		return Status{Code: StatusFileNotFound}
+	case errors.Is(err, ErrFileExists):
+		return Status{Code: StatusFileExists}
+	case errors.Is(err, ErrDiskFull), errors.Is(err, ErrDirectoryFull):
+		return Status{Code: StatusDiskFull}
+	case errors.As(err, &ce):
+		return Status{Code: StatusIllegalSector, Track: ce.Track, Sector: ce.Sector}
+	case errors.As(err, &ise):
+		return Status{Code: StatusIllegalSector, Track: ise.Track, Sector: ise.Sector}
+	}
+	return Status{Code: StatusSyntaxError}
+}
+
+// scratch handles "S0:PATTERN[,PATTERN]", reporting the amount of scratched files in the track field.
+func (dr *Drive) scratch(args string) Status {
+	if args == "" {
+		return Status{Code: StatusNoFilename}
+	}
+	found, err := dr.Disk.Find(args)
+	if err != nil {
+		return Status{Code: StatusSyntaxError}
+	}
+	n := 0
+	err = dr.Disk.Update(func(d *Disk) error {
+		seen := map[string]bool{}
+		for _, e := range found {
+			if seen[e.Filename] {
+				continue
+			}
+			seen[e.Filename] = true
+			m, err := d.Scratch(e.Filename)
+			if err != nil {
+				return err
+			}
+			n += m
+		}
+		return nil
+	})
+	if err != nil {
+		return statusFromError(err)
+	}
+	dr.modified = dr.modified || n > 0
+	return Status{Code: StatusFilesScratched, Track: byte(n)}
+}
+
+// rename handles "R0:NEW=OLD".
+func (dr *Drive) rename(args string) Status {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return Status{Code: StatusNoFilename}
+	}
+	newName, oldName := args[:i], stripDrive(args[i+1:])
+	if newName == "" || oldName == "" {
+		return Status{Code: StatusNoFilename}
+	}
+	if strings.ContainsAny(newName, "*?,") {
+		return Status{Code: StatusSyntaxError}
+	}
+	// the 1541 refuses to rename a file to its own name
+	if found, _ := dr.Disk.Find(newName); len(found) > 0 {
+		return Status{Code: StatusFileExists}
+	}
+	return dr.modify(statusFromError(dr.Disk.Rename(oldName, newName)))
+}
+
+// copy handles "C0:NEW=SRC[,SRC]", concatenating the source files into a new file.
+func (dr *Drive) copy(args string) Status {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return Status{Code: StatusNoFilename}
+	}
+	newName := args[:i]
+	if newName == "" || strings.ContainsAny(newName, "*?,") {
+		return Status{Code: StatusSyntaxError}
+	}
+	if found, _ := dr.Disk.Find(newName); len(found) > 0 {
+		return Status{Code: StatusFileExists}
+	}
+	var prg []byte
+	for _, src := range strings.Split(args[i+1:], ",") {
+		buf, err := dr.Disk.ReadFile(stripDrive(src))
+		if err != nil {
+			return statusFromError(err)
+		}
+		prg = append(prg, buf...)
+	}
+	return dr.modify(statusFromError(dr.Disk.AddPrg(NormalizeFilename(newName), prg)))
+}
+
+// format handles "N0:LABEL,ID" formatting the whole disk, or "N0:LABEL" clearing the directory and BAM, keeping the disk ID.
+func (dr *Drive) format(args string) Status {
+	if args == "" {
+		return Status{Code: StatusNoFilename}
+	}
+	d := dr.Disk
+	label, id := args, ""
+	if i := strings.IndexByte(args, ','); i >= 0 {
+		label, id = args[:i], args[i+1:]
+		if len(id) != 2 {
+			return Status{Code: StatusSyntaxError}
+		}
+	}
+	d.Label = strings.ToLower(label)
+	if id != "" {
+		dosType := "2a"
+		if len(d.DiskID) == MaxDiskIDSize {
+			dosType = d.DiskID[3:]
+		}
+		d.DiskID = strings.ToLower(id) + " " + dosType
+		for track := byte(1); track <= MaxTracks; track++ {
+			d.FormatTrack(track)
+		}
+	} else {
+		d.bam = [MaxTracks][MaxSectorsForBam]bool{}
+		d.raw = [MaxTracks][MaxSectors]bool{}
+	}
+	d.FormatDirectory()
+	d.FormatBAM()
+	d.setBamEntries()
+	return dr.modify(Status{})
+}
+
+// blockAllocate handles "B-A: 0 TRACK SECTOR" and "B-F: 0 TRACK SECTOR".
+// When allocating a used sector, status 65 reports the next free sector, like the 1541 does.
+func (dr *Drive) blockAllocate(args string, allocate bool) Status {
+	nums, err := dosNumbers(args, 3)
+	if err != nil {
+		return Status{Code: StatusSyntaxError}
+	}
+	track, sector := byte(nums[1]), byte(nums[2])
+	if nums[1] > MaxTracks || sectorIsValid(track, sector) != nil {
+		return Status{Code: StatusIllegalSector, Track: track, Sector: sector}
+	}
+	d := dr.Disk
+	if !allocate {
+		d.bam[track-1][sector] = false
+		d.raw[track-1][sector] = false
+		d.setBamEntries()
+		return dr.modify(Status{})
+	}
+	if d.bam[track-1][sector] {
+		for t := track; t <= MaxTracks; t++ {
+			if t == DirTrack {
+				continue
+			}
+			for s := byte(0); s < totalSectors(t); s++ {
+				if (t > track || s > sector) && !d.bam[t-1][s] {
+					return Status{Code: StatusNoBlock, Track: t, Sector: s}
+				}
+			}
+		}
+		return Status{Code: StatusNoBlock}
+	}
+	d.bam[track-1][sector] = true
+	d.setBamEntries()
+	return dr.modify(Status{})
+}
+
+// blockReadWrite handles "U1: CHANNEL 0 TRACK SECTOR" and "U2: CHANNEL 0 TRACK SECTOR",
+// reading the sector into or writing it from the channel buffer.
+func (dr *Drive) blockReadWrite(args string, read bool) Status {
+	nums, err := dosNumbers(args, 4)
+	if err != nil {
+		return Status{Code: StatusSyntaxError}
+	}
+	buf := dr.Buffer(nums[0])
+	if buf == nil {
+		return Status{Code: StatusNoChannel}
+	}
+	if nums[2] > MaxTracks || nums[3] >= MaxSectors {
+		return Status{Code: StatusIllegalSector, Track: byte(nums[2]), Sector: byte(nums[3])}
+	}
+	if ch := dr.channels[nums[0]]; ch != nil {
+		ch.pos = 0
+	}
+	track, sector := byte(nums[2]), byte(nums[3])
+	if read {
+		data, status := dr.ReadBlock(track, sector)
+		copy(buf, data)
+		return status
+	}
+	return dr.WriteBlock(track, sector, buf)
+}
+
+// ReadBlock returns a copy of the data of track, sector, or nil and status 66 if the sector does not exist.
+func (dr *Drive) ReadBlock(track, sector byte) ([]byte, Status) {
+	data, err := dr.Disk.ReadSector(track, sector)
+	return data, statusFromError(err)
+}
+
+// WriteBlock overwrites track, sector with data, which must be SectorSize bytes long.
+func (dr *Drive) WriteBlock(track, sector byte, data []byte) Status {
+	if len(data) != SectorSize {
+		return Status{Code: StatusSyntaxError}
+	}
+	return dr.modify(statusFromError(dr.Disk.WriteSector(track, sector, data)))
+}
+
+// Open opens name on channel, like OPEN 2,8,channel,"name" does.
+//
+// Channel 15 executes name as a DOS command. Channel 0 opens a file for reading and channel 1 for writing,
+// channels 2-14 read unless the name ends with ",W", e.g. "NAME,P,W". A "@0:" prefix replaces an existing file on close.
+// The name "#" opens the sector buffer of the channel, for use with the U1 and U2 commands.
+// Returns the resulting status, which is also returned by the next call to Status.
+func (dr *Drive) Open(channel int, name string) Status {
+	if channel == commandChannel {
+		if name == "" {
+			return dr.status
+		}
+		return dr.Exec(name)
+	}
+	delete(dr.channels, channel)
+	ch, status := dr.open(channel, name)
+	if ch != nil {
+		dr.channels[channel] = ch
+	}
+	dr.status = status
+	return status
+}
+
+func (dr *Drive) open(channel int, name string) (*driveChannel, Status) {
+	if channel < 0 || channel > lastDataChannel {
+		return nil, Status{Code: StatusNoChannel}
+	}
+	if strings.HasPrefix(name, "#") {
+		buf := dr.Buffer(channel)
+		if buf == nil {
+			return nil, Status{Code: StatusNoChannel}
+		}
+		return &driveChannel{data: buf, buffer: true}, Status{}
+	}
+	ch := &driveChannel{write: channel == 1}
+	if strings.HasPrefix(name, "@") {
+		ch.replace, name = true, name[1:]
+	}
+	parts := strings.Split(stripDrive(name), ",")
+	ch.name = parts[0]
+	for _, p := range parts[1:] {
+		switch strings.ToUpper(p) {
+		case "P", "PRG":
+		case "S", "SEQ", "U", "USR", "L", "REL":
+			return nil, Status{Code: StatusTypeMismatch}
+		case "R", "READ":
+			ch.write = false
+		case "W", "WRITE":
+			ch.write = true
+		default:
+			return nil, Status{Code: StatusSyntaxError}
+		}
+	}
+	if ch.name == "" {
+		return nil, Status{Code: StatusNoFilename}
+	}
+	if !ch.write {
+		data, err := dr.Disk.ReadFile(ch.name)
+		if err != nil {
+			return nil, statusFromError(err)
+		}
+		ch.data = data
+		return ch, Status{}
+	}
+	if strings.ContainsAny(ch.name, "*?") {
+		return nil, Status{Code: StatusInvalidFilename}
+	}
+	if found, _ := dr.Disk.Find(ch.name); len(found) > 0 && !ch.replace {
+		return nil, Status{Code: StatusFileExists}
+	}
+	return ch, Status{}
+}
+
+// Read reads up to len(p) bytes from channel into p and returns io.EOF at the end of the file.
+// Reading channel 15 returns the status, terminated by a carriage return.
+// Returns ErrFileNotOpen if channel is not open for reading.
+func (dr *Drive) Read(channel int, p []byte) (n int, err error) {
+	ch := dr.channels[channel]
+	if channel == commandChannel && (ch == nil || ch.pos >= len(ch.data)) {
+		ch = &driveChannel{data: []byte(dr.Status().String() + "\r")}
+		dr.channels[channel] = ch
+	}
+	if ch == nil || ch.write {
+		dr.status = Status{Code: StatusFileNotOpen}
+		return 0, fmt.Errorf("channel %d: %w", channel, ErrFileNotOpen)
+	}
+	if ch.pos >= len(ch.data) {
+		return 0, io.EOF
+	}
+	n = copy(p, ch.data[ch.pos:])
+	ch.pos += n
+	return n, nil
+}
+
+// Write writes p to channel. Writing to channel 15 executes p as a DOS command.
+// Writes to a sector buffer beyond SectorSize bytes return io.ErrShortWrite.
+// Returns ErrFileNotOpen if channel is not open for writing.
+func (dr *Drive) Write(channel int, p []byte) (n int, err error) {
+	if channel == commandChannel {
+		dr.Exec(string(p))
+		return len(p), nil
+	}
+	ch := dr.channels[channel]
+	switch {
+	case ch == nil || !ch.write && !ch.buffer:
+		dr.status = Status{Code: StatusFileNotOpen}
+		return 0, fmt.Errorf("channel %d: %w", channel, ErrFileNotOpen)
+	case ch.buffer:
+		n = copy(ch.data[ch.pos:], p)
+		ch.pos += n
+		if n < len(p) {
+			return n, io.ErrShortWrite
+		}
+		return n, nil
+	}
+	ch.data = append(ch.data, p...)
+	return len(p), nil
+}
+
+// Close closes channel. Files opened for writing are added to the Disk, replacing the existing file for "@0:" names.
+func (dr *Drive) Close(channel int) Status {
+	ch := dr.channels[channel]
+	delete(dr.channels, channel)
+	if ch == nil || !ch.write {
+		return Status{}
+	}
+	err := dr.Disk.Update(func(d *Disk) error {
+		if ch.replace {
+			if _, err := d.Scratch(ch.name); err != nil && !errors.Is(err, ErrFileNotFound) {
+				return err
+			}
+		}
+		return d.AddPrg(NormalizeFilename(ch.name), ch.data)
+	})
+	dr.status = dr.modify(statusFromError(err))
+	return dr.status
+}
+
+// dosNumbers parses n decimal numbers separated by spaces, commas or colons.
+func dosNumbers(args string, n int) (nums []int, err error) {
+	fields := strings.FieldsFunc(args, func(r rune) bool {
+		return r == ' ' || r == ',' || r == ':' || r == 0x1d
+	})
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d numbers, got %d", n, len(fields))
+	}
+	for _, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil || v < 0 || v > 0xff {
+			return nil, fmt.Errorf("illegal number %q", f)
+		}
+		nums = append(nums, v)
+	}
+	return nums, nil
+}
+
+// stripDrive removes a drive prefix like "0:" from a filename.
+func stripDrive(name string) string {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
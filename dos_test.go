@@ -0,0 +1,299 @@
+package d64
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newTestDrive(t *testing.T) *Drive {
+	d := NewDisk("dos", "01 2a", DefaultSectorInterleave)
+	for i, name := range []string{"intro", "demo1", "demo2"} {
+		if err := d.AddPrg(name, []byte{0x01, 0x08, byte(i)}); err != nil {
+			t.Fatalf("d.AddPrg %q failed: %v", name, err)
+		}
+	}
+	return NewDrive(d)
+}
+
+func TestStatusString(t *testing.T) {
+	cases := []struct {
+		s    Status
+		want string
+	}{
+		{Status{}, "00, OK,00,00"},
+		{Status{Code: StatusFilesScratched, Track: 2}, "01, FILES SCRATCHED,02,00"},
+		{Status{Code: StatusFileNotFound}, "62,FILE NOT FOUND,00,00"},
+		{Status{Code: StatusIllegalSector, Track: 36, Sector: 1}, "66,ILLEGAL TRACK OR SECTOR,36,01"},
+		{Status{Code: StatusDOSVersion}, "73,CBM DOS V2.6 1541,00,00"},
+	}
+	for _, c := range cases {
+		if got := c.s.String(); got != c.want {
+			t.Errorf("Status.String() == %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestDriveExec(t *testing.T) {
+	dr := newTestDrive(t)
+	if got := dr.Status().String(); got != "73,CBM DOS V2.6 1541,00,00" {
+		t.Errorf("power-on status == %q, want 73", got)
+	}
+	if got := dr.Status().String(); got != "00, OK,00,00" {
+		t.Errorf("status after read == %q, want 00", got)
+	}
+	cases := []struct {
+		cmd  string
+		want string
+	}{
+		{"S0:DEMO?", "01, FILES SCRATCHED,02,00"},
+		{"S:missing", "01, FILES SCRATCHED,00,00"},
+		{"R0:NEWINTRO=INTRO", "00, OK,00,00"},
+		{"R0:X=INTRO", "62,FILE NOT FOUND,00,00"},
+		{"R0:NEWINTRO=NEWINTRO", "63,FILE EXISTS,00,00"},
+		{"C0:BOTH=NEWINTRO,NEWINTRO", "00, OK,00,00"},
+		{"C0:BOTH=NEWINTRO", "63,FILE EXISTS,00,00"},
+		{"C0:X=MISSING", "62,FILE NOT FOUND,00,00"},
+		{"R0:NEW", "34,SYNTAX ERROR,00,00"},
+		{"X", "31,SYNTAX ERROR,00,00"},
+		{"V0", "00, OK,00,00"},
+		{"I0", "00, OK,00,00"},
+		{"UJ", "73,CBM DOS V2.6 1541,00,00"},
+	}
+	for _, c := range cases {
+		if got := dr.Exec(c.cmd).String(); got != c.want {
+			t.Errorf("dr.Exec(%q) == %q, want %q", c.cmd, got, c.want)
+		}
+	}
+	var names []string
+	for _, e := range dr.Disk.Directory() {
+		names = append(names, e.Filename)
+	}
+	if len(names) != 2 || names[0] != "newintro" || names[1] != "both" {
+		t.Errorf("directory == %q, want [newintro both]", names)
+	}
+	prg, err := dr.Disk.ReadFile("both")
+	if err != nil {
+		t.Fatalf("d.ReadFile failed: %v", err)
+	}
+	if want := []byte{0x01, 0x08, 0, 0x01, 0x08, 0}; !bytes.Equal(prg, want) {
+		t.Errorf("copied prg == % x, want % x", prg, want)
+	}
+}
+
+func TestDriveFormat(t *testing.T) {
+	dr := newTestDrive(t)
+	if got := dr.Exec("N0:EMPTY").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec N0:EMPTY == %q", got)
+	}
+	if len(dr.Disk.Directory()) != 0 || dr.Disk.BlocksFree() != MaxBlocks || dr.Disk.DiskID != "01 2a" {
+		t.Errorf("after N0:EMPTY: %d files, %d blocks free, id %q", len(dr.Disk.Directory()), dr.Disk.BlocksFree(), dr.Disk.DiskID)
+	}
+	if got := dr.Exec("N0:NEW,AB").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec N0:NEW,AB == %q", got)
+	}
+	if want := NewDisk("new", "ab 2a", DefaultSectorInterleave); !bytes.Equal(dr.Disk.data, want.data) {
+		t.Errorf("N0:NEW,AB does not match NewDisk")
+	}
+	if got := dr.Exec("N0:NEW,ABC").String(); got != "30,SYNTAX ERROR,00,00" {
+		t.Errorf("dr.Exec N0:NEW,ABC == %q, want 30", got)
+	}
+}
+
+func TestDriveBlocks(t *testing.T) {
+	dr := newTestDrive(t)
+	free := dr.Disk.BlocksFree()
+	cases := []struct {
+		cmd  string
+		want string
+	}{
+		{"B-A: 0 20 5", "00, OK,00,00"},
+		{"B-A: 0 20 5", "65,NO BLOCK,20,06"},
+		{"B-A 0,36,0", "66,ILLEGAL TRACK OR SECTOR,36,00"},
+		{"B-A: 0 1 21", "66,ILLEGAL TRACK OR SECTOR,01,21"},
+		{"B-F: 0 20 5", "00, OK,00,00"},
+		{"B-A: 0 20", "30,SYNTAX ERROR,00,00"},
+	}
+	for _, c := range cases {
+		if got := dr.Exec(c.cmd).String(); got != c.want {
+			t.Errorf("dr.Exec(%q) == %q, want %q", c.cmd, got, c.want)
+		}
+	}
+	if got := dr.Disk.BlocksFree(); got != free {
+		t.Errorf("BlocksFree == %d after B-A and B-F, want %d", got, free)
+	}
+
+	buf := dr.Buffer(2)
+	copy(buf, "hello")
+	if got := dr.Exec("U2: 2 0 20 5").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec U2 == %q", got)
+	}
+	copy(buf, "xxxxx")
+	if got := dr.Exec("U1: 2 0 20 5").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec U1 == %q", got)
+	}
+	if string(buf[:5]) != "hello" {
+		t.Errorf("buffer after U1 == %q, want %q", buf[:5], "hello")
+	}
+	copy(buf, "block")
+	if got := dr.Exec("B-W:2 0 20 6").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec B-W == %q", got)
+	}
+	copy(buf, "xxxxx")
+	if got := dr.Exec("b-r:2 0 20 6").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Exec B-R == %q", got)
+	}
+	if string(buf[:5]) != "block" {
+		t.Errorf("buffer after B-R == %q, want %q", buf[:5], "block")
+	}
+	if got := dr.Exec("U1: 15 0 20 5").String(); got != "70,NO CHANNEL,00,00" {
+		t.Errorf("dr.Exec U1 on channel 15 == %q, want 70", got)
+	}
+	if got := dr.Exec("U1: 2 0 40 0").String(); got != "66,ILLEGAL TRACK OR SECTOR,40,00" {
+		t.Errorf("dr.Exec U1 on track 40 == %q, want 66", got)
+	}
+}
+
+func TestDriveChannels(t *testing.T) {
+	dr := newTestDrive(t)
+	if got := dr.Open(2, "0:NEW,P,W").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Open write == %q", got)
+	}
+	for _, p := range [][]byte{{0x01, 0x08}, {0xea, 0x60}} {
+		if _, err := dr.Write(2, p); err != nil {
+			t.Fatalf("dr.Write failed: %v", err)
+		}
+	}
+	if got := dr.Close(2).String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Close == %q", got)
+	}
+	if got := dr.Open(1, "NEW").String(); got != "63,FILE EXISTS,00,00" {
+		t.Errorf("dr.Open existing for write == %q, want 63", got)
+	}
+	if got := dr.Open(1, "@0:NEW").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Open replace == %q", got)
+	}
+	dr.Write(1, []byte{0x01, 0x08, 0x60})
+	if got := dr.Close(1).String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Close replace == %q", got)
+	}
+
+	if got := dr.Open(0, "NE*").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Open read == %q", got)
+	}
+	buf := make([]byte, 2)
+	var got []byte
+	for {
+		n, err := dr.Read(0, buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("dr.Read failed: %v", err)
+		}
+	}
+	if want := []byte{0x01, 0x08, 0x60}; !bytes.Equal(got, want) {
+		t.Errorf("read % x, want % x", got, want)
+	}
+	dr.Close(0)
+	if _, err := dr.Read(0, buf); !errors.Is(err, ErrFileNotOpen) {
+		t.Errorf("dr.Read on closed channel == %v, want ErrFileNotOpen", err)
+	}
+
+	cases := []struct {
+		channel int
+		name    string
+		want    string
+	}{
+		{3, "MISSING", "62,FILE NOT FOUND,00,00"},
+		{3, "NEW,S", "64,FILE TYPE MISMATCH,00,00"},
+		{3, "NE*,W", "33,SYNTAX ERROR,00,00"},
+		{16, "NEW", "70,NO CHANNEL,00,00"},
+		{15, "S0:NEW", "01, FILES SCRATCHED,01,00"},
+	}
+	for _, c := range cases {
+		if got := dr.Open(c.channel, c.name).String(); got != c.want {
+			t.Errorf("dr.Open(%d, %q) == %q, want %q", c.channel, c.name, got, c.want)
+		}
+	}
+	status := make([]byte, 64)
+	n, _ := dr.Read(15, status)
+	if got := string(status[:n]); got != "01, FILES SCRATCHED,01,00\r" {
+		t.Errorf("dr.Read(15) == %q", got)
+	}
+}
+
+func TestDriveBufferChannel(t *testing.T) {
+	dr := newTestDrive(t)
+	if got := dr.Open(5, "#").String(); got != "00, OK,00,00" {
+		t.Fatalf("dr.Open # == %q", got)
+	}
+	dr.Write(5, []byte("block"))
+	dr.Write(15, []byte("U2: 5 0 1 0"))
+	if s := dr.Status(); !s.OK() {
+		t.Fatalf("U2 failed: %s", s)
+	}
+	if data, _ := dr.ReadBlock(1, 0); string(data[:5]) != "block" {
+		t.Errorf("ReadBlock(1, 0) == %q, want %q", data[:5], "block")
+	}
+	dr.Exec("U1: 5 0 18 0")
+	buf := make([]byte, SectorSize+1)
+	n, err := dr.Read(5, buf)
+	if err != nil || n != SectorSize || buf[0] != DirTrack {
+		t.Errorf("dr.Read(5) == %d, %v, first byte %d", n, err, buf[0])
+	}
+	if _, err = dr.Write(5, make([]byte, 1)); err != io.ErrShortWrite {
+		t.Errorf("dr.Write beyond buffer == %v, want io.ErrShortWrite", err)
+	}
+}
+
+func TestDriveValidate(t *testing.T) {
+	cases := []struct {
+		name          string
+		track, sector byte
+		want          string
+	}{
+		{"illegal link", 99, 0, "66,ILLEGAL TRACK OR SECTOR,01,00"},
+		{"loop", 1, 0, "66,ILLEGAL TRACK OR SECTOR,01,00"},
+	}
+	for _, c := range cases {
+		dr := NewDrive(NewDisk("validate", "01 2a", DefaultSectorInterleave))
+		if err := dr.Disk.AddPrg("file", make([]byte, 10)); err != nil {
+			t.Fatalf("d.AddPrg failed: %v", err)
+		}
+		free := dr.Disk.BlocksFree()
+		s := dr.Disk.sector(1, 0)
+		s.SetTrackLink(c.track)
+		s.SetSectorLink(c.sector)
+		if got := dr.Exec("V0").String(); got != c.want {
+			t.Errorf("%s: dr.Exec V0 == %q, want %q", c.name, got, c.want)
+		}
+		if got := dr.Disk.BlocksFree(); got != free {
+			t.Errorf("%s: BlocksFree == %d after failed V0, want %d", c.name, got, free)
+		}
+	}
+}
+
+func TestDriveModified(t *testing.T) {
+	dr := newTestDrive(t)
+	for _, cmd := range []string{"I0", "S0:MISSING", "R0:X=MISSING", "U1: 2 0 18 0", "B-A: 0 18 0"} {
+		if dr.Exec(cmd); dr.Modified() {
+			t.Errorf("dr.Exec(%q) modified the disk", cmd)
+		}
+	}
+	dr.Open(0, "INTRO")
+	dr.Read(0, make([]byte, 10))
+	dr.Close(0)
+	if dr.Modified() {
+		t.Errorf("reading a file modified the disk")
+	}
+	if dr.Exec("R0:NEW=INTRO"); !dr.Modified() {
+		t.Errorf("rename did not modify the disk")
+	}
+	dr.ResetModified()
+	if dr.Modified() {
+		t.Errorf("Modified after ResetModified")
+	}
+}
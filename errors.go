@@ -0,0 +1,54 @@
+package d64
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by disk operations, test for them with errors.Is.
+var (
+	// ErrDiskFull is returned when there are no free sectors left to store a file.
+	ErrDiskFull = errors.New("disk full")
+	// ErrDirectoryFull is returned when there are no free sectors left on the DirTrack for a new directory sector.
+	ErrDirectoryFull = errors.New("directory full")
+	// ErrFileNotFound is returned when a file does not exist in the directory.
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileExists is returned when a file already exists in the directory.
+	ErrFileExists = errors.New("file exists")
+	// ErrInvalidImage is returned when a file is not a valid .d64 image.
+	ErrInvalidImage = errors.New("invalid .d64 image")
+	// ErrFileNotOpen is returned when reading or writing a Drive channel that is not open.
+	ErrFileNotOpen = errors.New("file not open")
+)
+
+// An IllegalSectorError is returned for a track and sector outside of the disk geometry.
+type IllegalSectorError struct {
+	Track  byte
+	Sector byte
+}
+
+func (e *IllegalSectorError) Error() string {
+	return fmt.Sprintf("illegal track or sector: %d, %d", e.Track, e.Sector)
+}
+
+// A ChainError is returned for a broken sector chain of a file or the directory.
+// Track and Sector locate the sector containing the broken link.
+// Err is the underlying error, if any, e.g. an *IllegalSectorError for a link outside of the disk.
+type ChainError struct {
+	Track  byte
+	Sector byte
+	Reason string
+	Err    error
+}
+
+func (e *ChainError) Error() string {
+	s := fmt.Sprintf("broken chain on track %d, sector %d: %s", e.Track, e.Sector, e.Reason)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+func (e *ChainError) Unwrap() error {
+	return e.Err
+}
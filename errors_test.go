@@ -0,0 +1,95 @@
+package d64
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrDiskFull(t *testing.T) {
+	d := NewDisk("full", "01 2a", DefaultSectorInterleave)
+	err := d.AddPrg("big", make([]byte, (MaxBlocks+1)*BlockSize))
+	if !errors.Is(err, ErrDiskFull) {
+		t.Errorf("d.AddPrg on a full disk returned %v, want ErrDiskFull", err)
+	}
+}
+
+func TestErrDirectoryFull(t *testing.T) {
+	d := NewDisk("dirfull", "01 2a", DefaultSectorInterleave)
+	var err error
+	for i := 0; i <= MaxDirEntries && err == nil; i++ {
+		err = d.AddPrg(fmt.Sprintf("file%d", i), []byte{0x01, 0x08})
+	}
+	if !errors.Is(err, ErrDirectoryFull) {
+		t.Errorf("d.AddPrg on a full directory returned %v, want ErrDirectoryFull", err)
+	}
+}
+
+func TestErrFileNotFoundAndExists(t *testing.T) {
+	d := NewDisk("files", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrg("one", []byte{0x01, 0x08}); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	if err := d.AddPrg("two", []byte{0x01, 0x08}); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	if _, err := d.Scratch("three"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("d.Scratch of a missing file returned %v, want ErrFileNotFound", err)
+	}
+	if err := d.Rename("three", "four"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("d.Rename of a missing file returned %v, want ErrFileNotFound", err)
+	}
+	if err := d.Rename("one", "two"); !errors.Is(err, ErrFileExists) {
+		t.Errorf("d.Rename to an existing file returned %v, want ErrFileExists", err)
+	}
+}
+
+func TestIllegalSectorError(t *testing.T) {
+	d := NewDisk("illegal", "01 2a", DefaultSectorInterleave)
+	_, err := d.Extract(36, 0)
+	var ise *IllegalSectorError
+	if !errors.As(err, &ise) || ise.Track != 36 || ise.Sector != 0 {
+		t.Errorf("d.Extract(36, 0) returned %v, want *IllegalSectorError for track 36 sector 0", err)
+	}
+}
+
+func TestChainError(t *testing.T) {
+	d := NewDisk("chain", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrg("one", make([]byte, 3*BlockSize)); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	e := d.Directory()[0]
+	chain, err := d.chain(e.Track, e.Sector)
+	if err != nil {
+		t.Fatalf("d.chain failed: %v", err)
+	}
+
+	last := chain[len(chain)-1]
+	s := d.sector(last.Track, last.Sector)
+	s.SetTrackLink(e.Track)
+	s.SetSectorLink(e.Sector)
+	_, err = d.Extract(e.Track, e.Sector)
+	var ce *ChainError
+	if !errors.As(err, &ce) || ce.Track != last.Track || ce.Sector != last.Sector {
+		t.Errorf("d.Extract of a looped chain returned %v, want *ChainError on track %d sector %d", err, last.Track, last.Sector)
+	}
+
+	s.SetTrackLink(MaxTracks + 1)
+	_, err = d.Extract(e.Track, e.Sector)
+	var ise *IllegalSectorError
+	if !errors.As(err, &ce) || !errors.As(err, &ise) {
+		t.Errorf("d.Extract of an illegal link returned %v, want *ChainError wrapping *IllegalSectorError", err)
+	}
+}
+
+func TestLoadDiskInvalidImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.d64")
+	if err := os.WriteFile(path, make([]byte, 1000), 0644); err != nil {
+		t.Fatalf("os.WriteFile failed: %v", err)
+	}
+	if _, err := LoadDisk(path); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("LoadDisk of a short file returned %v, want ErrInvalidImage", err)
+	}
+}
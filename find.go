@@ -0,0 +1,97 @@
+package d64
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fileTypes are the file type letters accepted by a pattern type filter, like "*=P".
+const fileTypes = "DSPUR"
+
+// Find returns all files in the directory matching pattern, in directory order.
+//
+// Patterns follow the 1541 DOS semantics: '?' matches any single character and '*' matches the
+// remainder of the filename, characters after a '*' are ignored. Multiple patterns are separated by
+// commas and a pattern may end with a type filter, e.g. "intro*,demo?=P" matches prg files
+// starting with "intro" and five character prg files starting with "demo".
+// As only prg files are supported, all type filters other than P match nothing.
+// Matching is case-insensitive.
+func (d *Disk) Find(pattern string) ([]DirEntry, error) {
+	patterns, err := parsePatterns(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var found []DirEntry
+	for _, e := range d.Directory() {
+		for _, p := range patterns {
+			if p.match(e) {
+				found = append(found, e)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// ReadFile returns the content of the first file matching pattern, see Find for the pattern syntax.
+func (d *Disk) ReadFile(pattern string) ([]byte, error) {
+	found, err := d.Find(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, ErrFileNotFound)
+	}
+	e := found[0]
+	prg, err := d.Extract(e.Track, e.Sector)
+	if err != nil {
+		return prg, fmt.Errorf("d.Extract %q failed: %w", e.Filename, err)
+	}
+	return prg, nil
+}
+
+// A filePattern is a single 1541 filename pattern with an optional file type filter.
+type filePattern struct {
+	name     string
+	fileType byte
+}
+
+// parsePatterns parses a comma separated list of 1541 filename patterns.
+func parsePatterns(pattern string) (patterns []filePattern, err error) {
+	for _, s := range strings.Split(pattern, ",") {
+		name, p := s, filePattern{}
+		if i := strings.LastIndexByte(s, '='); i >= 0 {
+			t := strings.ToUpper(s[i+1:])
+			if len(t) != 1 || !strings.Contains(fileTypes, t) {
+				return nil, fmt.Errorf("illegal file type %q in pattern %q", s[i+1:], s)
+			}
+			name, p.fileType = s[:i], t[0]
+		}
+		p.name = strings.ToLower(name)
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// match returns true if e matches the pattern.
+func (p filePattern) match(e DirEntry) bool {
+	if p.fileType != 0 && p.fileType != 'P' {
+		return false
+	}
+	return matchName(p.name, e.Filename)
+}
+
+// matchName returns true if name matches the 1541 filename pattern.
+func matchName(pattern, name string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '*':
+			return true
+		case i >= len(name):
+			return false
+		case pattern[i] != '?' && pattern[i] != name[i]:
+			return false
+		}
+	}
+	return len(pattern) == len(name)
+}
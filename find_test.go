@@ -0,0 +1,104 @@
+package d64
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMatchName(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"intro", "intro", true},
+		{"intro", "intro2", false},
+		{"intro2", "intro", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"in*", "intro", true},
+		{"in*xyz", "intro", true},
+		{"in*", "demo", false},
+		{"?ntro", "intro", true},
+		{"????", "intro", false},
+		{"?????", "intro", true},
+		{"demo?", "demo1", true},
+	}
+	for _, c := range cases {
+		if got := matchName(c.pattern, c.name); got != c.want {
+			t.Errorf("matchName(%q, %q) == %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	d := NewDisk("find", "01 2a", DefaultSectorInterleave)
+	for i, name := range []string{"intro", "demo1", "demo2", "note"} {
+		if err := d.AddPrg(name, []byte{0x01, 0x08, byte(i)}); err != nil {
+			t.Fatalf("d.AddPrg %q failed: %v", name, err)
+		}
+	}
+	cases := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*", []string{"intro", "demo1", "demo2", "note"}},
+		{"demo?", []string{"demo1", "demo2"}},
+		{"NOTE,in*", []string{"intro", "note"}},
+		{"*=p", []string{"intro", "demo1", "demo2", "note"}},
+		{"*=s", nil},
+		{"*=s,demo1=p", []string{"demo1"}},
+		{"missing", nil},
+		{"\u212a\u212a\u212a=P", nil},
+	}
+	for _, c := range cases {
+		found, err := d.Find(c.pattern)
+		if err != nil {
+			t.Errorf("d.Find(%q) failed: %v", c.pattern, err)
+			continue
+		}
+		var got []string
+		for _, e := range found {
+			got = append(got, e.Filename)
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("d.Find(%q) == %v, want %v", c.pattern, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("d.Find(%q) == %v, want %v", c.pattern, got, c.want)
+				break
+			}
+		}
+	}
+	if _, err := d.Find("*=x"); err == nil {
+		t.Errorf("d.Find with illegal file type should have failed")
+	}
+
+	prg, err := d.ReadFile("demo*")
+	if err != nil {
+		t.Fatalf("d.ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(prg, []byte{0x01, 0x08, 1}) {
+		t.Errorf("d.ReadFile(%q) == %v, want the content of demo1", "demo*", prg)
+	}
+	if _, err = d.ReadFile("missing"); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("d.ReadFile of a missing file returned %v, want ErrFileNotFound", err)
+	}
+}
+
+func TestExtractMatchingToPath(t *testing.T) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	first := d.Directory()[0].Filename
+	paths, err := d.ExtractMatchingToPath(t.TempDir(), first)
+	if err != nil {
+		t.Fatalf("d.ExtractMatchingToPath failed: %v", err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("d.ExtractMatchingToPath(%q) extracted %d files, want 1", first, len(paths))
+	}
+}
@@ -0,0 +1,131 @@
+package d64
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Supported layout formats for WriteLayout.
+const (
+	LayoutACME    = "acme"
+	LayoutKickAss = "kickass"
+	Layout64tass  = "64tass"
+	LayoutJSON    = "json"
+)
+
+// layoutPerLine is the maximum amount of bytes per line in assembler tables.
+const layoutPerLine = 16
+
+// A FileLayout describes where a file is stored on the disk.
+type FileLayout struct {
+	Filename string        `json:"filename"`
+	Label    string        `json:"label"`
+	Track    byte          `json:"track"`
+	Sector   byte          `json:"sector"`
+	Blocks   int           `json:"blocks"`
+	Chain    []TrackSector `json:"chain"`
+}
+
+// Layout returns the FileLayout of every file in the directory, in directory order.
+// Each FileLayout has a unique Label, usable as symbol in assembler sources.
+func (d *Disk) Layout() (layout []FileLayout, err error) {
+	seen := map[string]int{}
+	for _, e := range d.Directory() {
+		chain, err := d.chain(e.Track, e.Sector)
+		if err != nil {
+			return layout, fmt.Errorf("d.chain %q failed: %w", e.Filename, err)
+		}
+		label := layoutLabel(e.Filename)
+		seen[label]++
+		if seen[label] > 1 {
+			label += "_" + strconv.Itoa(seen[label])
+		}
+		layout = append(layout, FileLayout{
+			Filename: e.Filename,
+			Label:    label,
+			Track:    e.Track,
+			Sector:   e.Sector,
+			Blocks:   len(chain),
+			Chain:    chain,
+		})
+	}
+	return layout, nil
+}
+
+var reLayoutLabel = regexp.MustCompile("[^a-z0-9_]")
+
+// layoutLabel returns a symbol name for filename, valid in all supported assemblers.
+func layoutLabel(filename string) string {
+	return "file_" + reLayoutLabel.ReplaceAllString(strings.ToLower(filename), "_")
+}
+
+// WriteLayout writes the Layout of all files on d to w, as include file in format.
+// Supported formats are LayoutACME, LayoutKickAss, Layout64tass and LayoutJSON.
+func WriteLayout(w io.Writer, d *Disk, format string) error {
+	layout, err := d.Layout()
+	if err != nil {
+		return fmt.Errorf("d.Layout failed: %w", err)
+	}
+	if format == LayoutJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		if err = enc.Encode(layout); err != nil {
+			return fmt.Errorf("enc.Encode failed: %w", err)
+		}
+		return nil
+	}
+
+	var comment, label, byteDirective string
+	switch format {
+	case LayoutACME:
+		comment, label, byteDirective = ";", "%s = %d\n", "!byte"
+	case LayoutKickAss:
+		comment, label, byteDirective = "//", ".label %s = %d\n", ".byte"
+	case Layout64tass:
+		comment, label, byteDirective = ";", "%s = %d\n", ".byte"
+	default:
+		return fmt.Errorf("unsupported layout format %q", format)
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s generated by d64 %s from %q %q\n", comment, Version, d.Label, d.DiskID)
+	for _, f := range layout {
+		fmt.Fprintf(b, "\n%s %q\n", comment, f.Filename)
+		fmt.Fprintf(b, label, f.Label+"_track", f.Track)
+		fmt.Fprintf(b, label, f.Label+"_sector", f.Sector)
+		fmt.Fprintf(b, label, f.Label+"_blocks", f.Blocks)
+		tracks, sectors := make([]byte, len(f.Chain)), make([]byte, len(f.Chain))
+		for i, ts := range f.Chain {
+			tracks[i], sectors[i] = ts.Track, ts.Sector
+		}
+		writeLayoutBytes(b, format, f.Label+"_chain_tracks", byteDirective, tracks)
+		writeLayoutBytes(b, format, f.Label+"_chain_sectors", byteDirective, sectors)
+	}
+	if _, err = io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("io.WriteString failed: %w", err)
+	}
+	return nil
+}
+
+// writeLayoutBytes writes a labeled table of bytes to b.
+func writeLayoutBytes(b *strings.Builder, format, label, byteDirective string, buf []byte) {
+	if format == LayoutKickAss {
+		label += ":"
+	}
+	b.WriteString(label + "\n")
+	for i := 0; i < len(buf); i += layoutPerLine {
+		line := buf[i:]
+		if len(line) > layoutPerLine {
+			line = line[:layoutPerLine]
+		}
+		values := make([]string, len(line))
+		for j, v := range line {
+			values[j] = strconv.Itoa(int(v))
+		}
+		b.WriteString("\t" + byteDirective + " " + strings.Join(values, ", ") + "\n")
+	}
+}
@@ -0,0 +1,77 @@
+package d64
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	d, err := LoadDisk(validatedD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", validatedD64, err)
+	}
+	layout, err := d.Layout()
+	if err != nil {
+		t.Fatalf("d.Layout failed: %v", err)
+	}
+	if len(layout) != 2 {
+		t.Fatalf("d.Layout returned %d files, want %d", len(layout), 2)
+	}
+	for i, e := range d.Directory() {
+		f := layout[i]
+		if f.Track != e.Track || f.Sector != e.Sector || f.Filename != e.Filename {
+			t.Errorf("layout %q does not match directory entry %q", f.Filename, e.Filename)
+		}
+		if f.Chain[0] != (TrackSector{e.Track, e.Sector}) {
+			t.Errorf("layout %q chain starts at %v", f.Filename, f.Chain[0])
+		}
+		prg, _ := d.Extract(e.Track, e.Sector)
+		if f.Blocks != len(f.Chain) || f.Blocks != (len(prg)+BlockSize-1)/BlockSize {
+			t.Errorf("layout %q blocks %d incorrect for %d bytes", f.Filename, f.Blocks, len(prg))
+		}
+	}
+	if layout[0].Label != "file_file_0" {
+		t.Errorf("layout label got %q want %q", layout[0].Label, "file_file_0")
+	}
+}
+
+func TestWriteLayout(t *testing.T) {
+	d, err := LoadDisk(validatedD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", validatedD64, err)
+	}
+	cases := []struct {
+		format, want string
+	}{
+		{LayoutACME, "file_file_0_track = 1\n"},
+		{LayoutKickAss, ".label file_file_1_track = 3\n"},
+		{Layout64tass, "file_file_1_chain_sectors\n\t.byte 0, 10"},
+	}
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		if err = WriteLayout(buf, d, c.format); err != nil {
+			t.Fatalf("WriteLayout %q failed: %v", c.format, err)
+		}
+		if !strings.Contains(buf.String(), c.want) {
+			t.Errorf("WriteLayout %q does not contain %q", c.format, c.want)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err = WriteLayout(buf, d, LayoutJSON); err != nil {
+		t.Fatalf("WriteLayout %q failed: %v", LayoutJSON, err)
+	}
+	var layout []FileLayout
+	if err = json.Unmarshal(buf.Bytes(), &layout); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if len(layout) != 2 || layout[1].Track != 3 {
+		t.Errorf("WriteLayout %q returned unexpected layout: %v", LayoutJSON, layout)
+	}
+
+	if err = WriteLayout(buf, d, "dasm"); err == nil {
+		t.Errorf("WriteLayout with unsupported format should have failed")
+	}
+}
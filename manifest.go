@@ -0,0 +1,144 @@
+package d64
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Defaults used by Manifest when left empty.
+const (
+	DefaultManifestID      = "01"
+	DefaultManifestDOSType = "2a"
+)
+
+// A Manifest declares a complete disk, its files and their placement, to be built in one reproducible step.
+// Source and Output paths are relative to the directory of the manifest file.
+type Manifest struct {
+	Label      string         `json:"label"`
+	ID         string         `json:"id"`
+	DOSType    string         `json:"dos_type"`
+	Tracks     int            `json:"tracks"`
+	Allocation string         `json:"allocation"`
+	Interleave byte           `json:"interleave"`
+	DirTrack   bool           `json:"dir_track"`
+	DirReserve *byte          `json:"dir_reserve"`
+	Output     string         `json:"output"`
+	Files      []ManifestFile `json:"files"`
+
+	dir string
+}
+
+// A ManifestFile declares a single file of a Manifest.
+// An empty Name is derived from Source, only the "prg" Type is supported.
+type ManifestFile struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Source     string `json:"source"`
+	Interleave byte   `json:"interleave"`
+	DirTrack   bool   `json:"dir_track"`
+	Track      byte   `json:"track"`
+	Sector     byte   `json:"sector"`
+	FirstTrack byte   `json:"first_track"`
+	LastTrack  byte   `json:"last_track"`
+}
+
+// LoadManifest reads the JSON manifest at path.
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open %q failed: %w", path, err)
+	}
+	defer f.Close()
+	m := &Manifest{dir: filepath.Dir(path)}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("dec.Decode %q failed: %w", path, err)
+	}
+	if m.Output == "" {
+		m.Output = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + ".d64"
+	}
+	return m, nil
+}
+
+// OutputPath returns the path of the .d64 to be written, relative to the directory of the manifest.
+func (m *Manifest) OutputPath() string {
+	return m.path(m.Output)
+}
+
+// path returns p relative to the directory of the manifest, unless p is absolute.
+func (m *Manifest) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+// DiskID returns the 5 character disk ID, consisting of ID and DOSType.
+func (m *Manifest) DiskID() (string, error) {
+	id, dosType := m.ID, m.DOSType
+	if id == "" {
+		id = DefaultManifestID
+	}
+	if len(id) == MaxDiskIDSize && dosType == "" {
+		return id, nil
+	}
+	if dosType == "" {
+		dosType = DefaultManifestDOSType
+	}
+	if len(id) > 2 || len(dosType) > 2 {
+		return "", fmt.Errorf("illegal id %q and dos type %q, both can be 2 characters max", id, dosType)
+	}
+	return fmt.Sprintf("%-2s %s", id, dosType), nil
+}
+
+// Build returns a new Disk containing all files of the manifest.
+func (m *Manifest) Build() (*Disk, error) {
+	if m.Tracks != 0 && m.Tracks != MaxTracks {
+		return nil, fmt.Errorf("unsupported amount of tracks %d, only %d is supported", m.Tracks, MaxTracks)
+	}
+	diskID, err := m.DiskID()
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := AllocationByName(m.Allocation)
+	if err != nil {
+		return nil, err
+	}
+	interleave := m.Interleave
+	if interleave == 0 {
+		interleave = DefaultSectorInterleave
+	}
+	d := NewDisk(m.Label, diskID, interleave)
+	d.Allocation = allocation
+	d.UseDirTrack = m.DirTrack
+	d.DirReserve = m.DirReserve
+
+	for _, f := range m.Files {
+		if f.Type != "" && strings.ToLower(f.Type) != "prg" {
+			return d, fmt.Errorf("file %q has unsupported type %q", f.Source, f.Type)
+		}
+		name := f.Name
+		if name == "" {
+			name = NormalizeFilename(filepath.Base(f.Source))
+			if ext := filepath.Ext(f.Source); strings.ToLower(ext) == ".prg" {
+				name = strings.TrimSuffix(name, strings.ToLower(ext))
+			}
+		}
+		opt := AddOptions{
+			UseDirTrack: f.DirTrack,
+			Interleave:  f.Interleave,
+			Track:       f.Track,
+			Sector:      f.Sector,
+			FirstTrack:  f.FirstTrack,
+			LastTrack:   f.LastTrack,
+		}
+		if err = d.AddFileWithOptions(m.path(f.Source), name, opt); err != nil {
+			return d, fmt.Errorf("d.AddFileWithOptions %q failed: %w", f.Source, err)
+		}
+	}
+	return d, nil
+}
@@ -0,0 +1,121 @@
+package d64
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestManifest(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "test.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile %q failed: %v", path, err)
+	}
+	return path
+}
+
+func TestManifestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testmanifest")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := filepath.Abs(testPrg1)
+	if err != nil {
+		t.Fatalf("filepath.Abs failed: %v", err)
+	}
+
+	path := writeTestManifest(t, dir, `{
+	"label": "manifest",
+	"id": "ab",
+	"allocation": "dos",
+	"files": [
+		{"name": "boot", "source": "`+src+`"},
+		{"source": "`+src+`", "interleave": 4, "first_track": 30, "last_track": 35},
+		{"name": "pinned", "source": "`+src+`", "track": 1, "sector": 5}
+	]
+}`)
+	m, err := LoadManifest(path)
+	if err != nil {
+		t.Fatalf("LoadManifest %q failed: %v", path, err)
+	}
+	if got, want := m.OutputPath(), filepath.Join(dir, "test.d64"); got != want {
+		t.Errorf("m.OutputPath got %q want %q", got, want)
+	}
+	d, err := m.Build()
+	if err != nil {
+		t.Fatalf("m.Build failed: %v", err)
+	}
+	if d.DiskID != "ab 2a" {
+		t.Errorf("d.DiskID got %q want %q", d.DiskID, "ab 2a")
+	}
+	dir2 := d.Directory()
+	if len(dir2) != 3 {
+		t.Fatalf("built disk contains %d files, want %d", len(dir2), 3)
+	}
+	want := []struct {
+		name          string
+		track, sector byte
+	}{{"boot", 17, 0}, {"testfile1", 30, 0}, {"pinned", 1, 5}}
+	for i, w := range want {
+		e := dir2[i]
+		if e.Filename != w.name || e.Track != w.track || e.Sector != w.sector {
+			t.Errorf("file %d got %q on %d, %d want %q on %d, %d", i, e.Filename, e.Track, e.Sector, w.name, w.track, w.sector)
+		}
+	}
+	if interleave, _ := d.FileInterleave(dir2[1]); interleave != 4 {
+		t.Errorf("file %q interleave got %d want %d", dir2[1].Filename, interleave, 4)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testmanifesterrors")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = LoadManifest(writeTestManifest(t, dir, `{"labell": "typo"}`)); err == nil {
+		t.Errorf("LoadManifest with unknown field should have failed")
+	}
+	cases := []string{
+		`{"tracks": 40}`,
+		`{"allocation": "random"}`,
+		`{"id": "toolong"}`,
+		`{"files": [{"source": "foo.seq", "type": "seq"}]}`,
+		`{"files": [{"source": "doesnotexist.prg"}]}`,
+	}
+	for _, c := range cases {
+		m, err := LoadManifest(writeTestManifest(t, dir, c))
+		if err != nil {
+			t.Fatalf("LoadManifest %s failed: %v", c, err)
+		}
+		if _, err = m.Build(); err == nil {
+			t.Errorf("m.Build %s should have failed", c)
+		}
+	}
+}
+
+func TestManifestDiskID(t *testing.T) {
+	cases := []struct {
+		id, dosType, want string
+	}{
+		{"", "", "01 2a"},
+		{"ab", "", "ab 2a"},
+		{"ab", "4a", "ab 4a"},
+		{"x", "", "x  2a"},
+		{"01 2a", "", "01 2a"},
+	}
+	for _, c := range cases {
+		m := &Manifest{ID: c.id, DOSType: c.dosType}
+		got, err := m.DiskID()
+		if err != nil {
+			t.Errorf("m.DiskID %q %q failed: %v", c.id, c.dosType, err)
+		}
+		if got != c.want {
+			t.Errorf("m.DiskID %q %q got %q want %q", c.id, c.dosType, got, c.want)
+		}
+	}
+}
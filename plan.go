@@ -0,0 +1,107 @@
+package d64
+
+import "fmt"
+
+// dirEntriesPerSector is the amount of directory entries in a single directory sector.
+const dirEntriesPerSector = SectorSize / dirEntrySize
+
+// A Plan reports the capacity needed to add files to a disk, see Disk.Plan.
+type Plan struct {
+	BlocksNeeded   int
+	BlocksFree     int
+	DirSlotsNeeded int
+	DirSlotsFree   int
+	Files          []FilePlan
+}
+
+// A FilePlan reports where a single file would be stored.
+type FilePlan struct {
+	Name   string
+	Track  byte
+	Sector byte
+	Blocks int
+	Chain  []TrackSector
+}
+
+// Fits returns true if all files of the plan could be placed.
+func (p *Plan) Fits() bool {
+	return len(p.Files) == p.DirSlotsNeeded
+}
+
+// Plan reports the blocks and directory slots needed to add files and where each file would land, without modifying the disk.
+// Files are planned in order, if a file does not fit the returned Plan contains the files placed so far and an error is returned.
+func (d *Disk) Plan(files []File) (*Plan, error) {
+	p := &Plan{
+		BlocksFree:     d.BlocksFree(),
+		DirSlotsNeeded: len(files),
+		DirSlotsFree:   d.dirSlotsFree(),
+	}
+	for _, f := range files {
+		p.BlocksNeeded += SizeToBlocks(len(f.Prg))
+	}
+
+	// c is discarded, so files are added without a transaction of their own
+	c := d.Clone()
+	for _, f := range files {
+		opt := f.Options
+		opt.UseDirTrack = opt.UseDirTrack || c.UseDirTrack
+		if err := opt.validate(); err != nil {
+			return p, fmt.Errorf("invalid options for %q: %w", f.Name, err)
+		}
+		track, sector, err := c.freeSector(opt)
+		if err != nil {
+			return p, fmt.Errorf("c.freeSector %q failed: %w", f.Name, err)
+		}
+		if len(f.Prg) == 0 {
+			return p, fmt.Errorf("prg file %q is empty", f.Name)
+		}
+		if err = c.addPrg(f.Name, f.Prg, opt); err != nil {
+			return p, fmt.Errorf("c.addPrg %q failed: %w", f.Name, err)
+		}
+		chain, err := c.chain(track, sector)
+		if err != nil {
+			return p, fmt.Errorf("c.chain %q failed: %w", f.Name, err)
+		}
+		p.Files = append(p.Files, FilePlan{
+			Name:   f.Name,
+			Track:  track,
+			Sector: sector,
+			Blocks: len(chain),
+			Chain:  chain,
+		})
+	}
+	return p, nil
+}
+
+// dirSlotsFree returns the amount of free slots in the directory, including slots in unallocated DirTrack sectors.
+// A slot is free if it is empty or scratched, see dirSlotIsFree.
+func (d *Disk) dirSlotsFree() (n int) {
+	track, sector := byte(DirTrack), byte(1)
+	for i := byte(0); i < totalSectors(DirTrack); i++ {
+		s := d.sector(track, sector)
+		for j := 2; j < SectorSize; j += dirEntrySize {
+			if dirSlotIsFree(s, j) {
+				n++
+			}
+		}
+		if s.TrackLink() == 0 {
+			break
+		}
+		track, sector = s.TrackLink(), s.SectorLink()
+		if sectorIsValid(track, sector) != nil {
+			break
+		}
+	}
+	for sector := byte(0); sector < totalSectors(DirTrack); sector++ {
+		if !d.bam[DirTrack-1][sector] {
+			n += dirEntriesPerSector
+		}
+	}
+	return n
+}
+
+// dirSlotIsFree returns true if the directory entry at offset i of s is empty or scratched.
+// Entries of other file types, e.g. SEQ or USR files, are in use.
+func dirSlotIsFree(s *Sector, i int) bool {
+	return s.Data[i] == 0
+}
@@ -0,0 +1,109 @@
+package d64
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddPrgRollback(t *testing.T) {
+	d := NewDisk("rollback", "01 2a", DefaultSectorInterleave)
+	if err := d.AddFile(testPrg1, "keep"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	before := &bytes.Buffer{}
+	if _, err := d.WriteTo(before); err != nil {
+		t.Fatalf("d.WriteTo failed: %v", err)
+	}
+	bam := d.bam
+
+	if err := d.AddPrg("toolarge", make([]byte, MaxBlocks*BlockSize)); err == nil {
+		t.Fatalf("d.AddPrg of a file larger than the disk should have failed")
+	}
+	after := &bytes.Buffer{}
+	if _, err := d.WriteTo(after); err != nil {
+		t.Fatalf("d.WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Errorf("failed d.AddPrg modified the disk")
+	}
+	if bam != d.bam {
+		t.Errorf("failed d.AddPrg modified d.bam")
+	}
+	if len(d.Directory()) != 1 {
+		t.Errorf("failed d.AddPrg left a dangling directory entry")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	d := NewDisk("plan", "01 2a", DefaultSectorInterleave)
+	if err := d.AddFile(testPrg1, "existing"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	before := d.CanonicalHash()
+	files := []File{
+		{Name: "one", Prg: make([]byte, 10*BlockSize)},
+		{Name: "two", Prg: make([]byte, 5), Options: AddOptions{Track: 30, Sector: 3}},
+	}
+	p, err := d.Plan(files)
+	if err != nil {
+		t.Fatalf("d.Plan failed: %v", err)
+	}
+	if d.CanonicalHash() != before {
+		t.Errorf("d.Plan modified the disk")
+	}
+	if !p.Fits() {
+		t.Errorf("plan should fit")
+	}
+	if p.BlocksNeeded != 11 {
+		t.Errorf("p.BlocksNeeded got %d want %d", p.BlocksNeeded, 11)
+	}
+	if p.BlocksFree != MaxBlocks-42 {
+		t.Errorf("p.BlocksFree got %d want %d", p.BlocksFree, MaxBlocks-42)
+	}
+	if p.DirSlotsNeeded != 2 || p.DirSlotsFree != MaxDirEntries-1 {
+		t.Errorf("p.DirSlots needed %d free %d, want %d and %d", p.DirSlotsNeeded, p.DirSlotsFree, 2, MaxDirEntries-1)
+	}
+	if f := p.Files[0]; f.Track != 3 || f.Blocks != 10 || len(f.Chain) != 10 {
+		t.Errorf("file %q planned on track %d with %d blocks, want track 3 and 10 blocks", f.Name, f.Track, f.Blocks)
+	}
+	if f := p.Files[1]; f.Track != 30 || f.Sector != 3 || f.Blocks != 1 {
+		t.Errorf("file %q planned on track %d sector %d, want track 30 sector 3", f.Name, f.Track, f.Sector)
+	}
+
+	p, err = d.Plan(append(files, File{Name: "toolarge", Prg: make([]byte, MaxBlocks*BlockSize)}))
+	if err == nil {
+		t.Errorf("d.Plan of too many blocks should have failed")
+	}
+	if p.Fits() || len(p.Files) != 2 {
+		t.Errorf("plan should not fit and contain %d files, got %d", 2, len(p.Files))
+	}
+}
+
+func TestPlanDirSlots(t *testing.T) {
+	d := NewDisk("plan", "01 2a", DefaultSectorInterleave)
+	if err := d.AddFile(testPrg1, "prg"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	if err := d.AddFile(testPrg1, "seq"); err != nil {
+		t.Fatalf("d.AddFile %q failed: %v", testPrg1, err)
+	}
+	// turn the second file into a SEQ file, which the directory slot search must keep
+	slot := d.findSlots("seq")[0]
+	d.sector(slot.dir.Track, slot.dir.Sector).Data[slot.offset] = 0x81
+	p, err := d.Plan([]File{{Name: "one", Prg: make([]byte, BlockSize)}})
+	if err != nil {
+		t.Fatalf("d.Plan failed: %v", err)
+	}
+	if p.DirSlotsFree != MaxDirEntries-2 {
+		t.Errorf("p.DirSlotsFree got %d want %d", p.DirSlotsFree, MaxDirEntries-2)
+	}
+	if p.BlocksNeeded != 1 || p.Files[0].Blocks != 1 {
+		t.Errorf("p.BlocksNeeded %d and planned blocks %d for %d bytes, want 1", p.BlocksNeeded, p.Files[0].Blocks, BlockSize)
+	}
+	if err = d.AddPrg("one", make([]byte, BlockSize)); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	if got := d.sector(slot.dir.Track, slot.dir.Sector).Data[slot.offset]; got != 0x81 {
+		t.Errorf("d.AddPrg overwrote the SEQ entry, file type got %#x want %#x", got, 0x81)
+	}
+}
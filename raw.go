@@ -0,0 +1,103 @@
+package d64
+
+import "fmt"
+
+// SectorRange returns all sectors from first up to and including last, in the order they are stored in the .d64.
+func SectorRange(first, last TrackSector) (sectors []TrackSector, err error) {
+	if err = sectorIsValid(first.Track, first.Sector); err != nil {
+		return nil, err
+	}
+	if err = sectorIsValid(last.Track, last.Sector); err != nil {
+		return nil, err
+	}
+	if trackSectorToDataOffset(first.Track, first.Sector) > trackSectorToDataOffset(last.Track, last.Sector) {
+		return nil, fmt.Errorf("illegal sector range: track %d sector %d is located after track %d sector %d", first.Track, first.Sector, last.Track, last.Sector)
+	}
+	ts := first
+	for {
+		sectors = append(sectors, ts)
+		if ts == last {
+			return sectors, nil
+		}
+		ts.Sector++
+		if ts.Sector >= totalSectors(ts.Track) {
+			ts.Track, ts.Sector = ts.Track+1, 0
+		}
+	}
+}
+
+// WriteRaw writes data across sectors using all SectorSize bytes per sector, without track/sector links.
+// All sectors are written, the remainder is zero-filled, and marked used in the BAM without adding a directory entry.
+// Returns an error if data does not fit, or if any of the sectors is invalid or already allocated.
+// The sectors are recorded on the Disk, so Validate keeps them allocated. This record is not stored in the .d64,
+// Validate on a Disk loaded later frees them again, as they are not part of any file.
+func (d *Disk) WriteRaw(sectors []TrackSector, data []byte) error {
+	if len(data) > len(sectors)*SectorSize {
+		return fmt.Errorf("raw data of %d bytes does not fit in %d sectors", len(data), len(sectors))
+	}
+	opt := AddOptions{UseDirTrack: d.UseDirTrack}
+	seen := map[TrackSector]bool{}
+	for _, ts := range sectors {
+		if err := sectorIsValid(ts.Track, ts.Sector); err != nil {
+			return err
+		}
+		if seen[ts] {
+			return fmt.Errorf("track %d sector %d is listed more than once", ts.Track, ts.Sector)
+		}
+		seen[ts] = true
+		if !d.sectorIsFree(ts.Track, ts.Sector, opt) {
+			return fmt.Errorf("track %d sector %d is not available for raw storage", ts.Track, ts.Sector)
+		}
+	}
+
+	for _, ts := range sectors {
+		s := d.sector(ts.Track, ts.Sector)
+		s.zero()
+		n := copy(s.Data, data)
+		data = data[n:]
+		d.bam[ts.Track-1][ts.Sector] = true
+		d.raw[ts.Track-1][ts.Sector] = true
+	}
+	d.setBamEntries()
+	return nil
+}
+
+// ReadRaw returns the full content of all sectors, including the bytes usually containing track/sector links.
+func (d *Disk) ReadRaw(sectors []TrackSector) ([]byte, error) {
+	buf := make([]byte, 0, len(sectors)*SectorSize)
+	for _, ts := range sectors {
+		if err := sectorIsValid(ts.Track, ts.Sector); err != nil {
+			return buf, err
+		}
+		buf = append(buf, d.sector(ts.Track, ts.Sector).Data...)
+	}
+	return buf, nil
+}
+
+// ReadSector returns a copy of all SectorSize bytes of track, sector, including the track/sector link.
+func (d *Disk) ReadSector(track, sector byte) ([]byte, error) {
+	if err := sectorIsValid(track, sector); err != nil {
+		return nil, err
+	}
+	buf := append([]byte(nil), d.sector(track, sector).Data...)
+	if err := d.Err(); err != nil {
+		return buf, err
+	}
+	return buf, nil
+}
+
+// WriteSector overwrites all SectorSize bytes of track, sector with data, including the track/sector link.
+// The BAM is not updated, unless the BAM sector itself is written: then the BAM, Label and DiskID are reloaded from data.
+func (d *Disk) WriteSector(track, sector byte, data []byte) error {
+	if err := sectorIsValid(track, sector); err != nil {
+		return err
+	}
+	if len(data) != SectorSize {
+		return fmt.Errorf("sector data must be %d bytes, got %d", SectorSize, len(data))
+	}
+	copy(d.sector(track, sector).Data, data)
+	if track == DirTrack && sector == 0 {
+		d.loadBAM()
+	}
+	return d.Err()
+}
@@ -0,0 +1,139 @@
+package d64
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSectorRange(t *testing.T) {
+	cases := []struct {
+		first, last TrackSector
+		want        int
+		fail        bool
+	}{
+		{TrackSector{1, 0}, TrackSector{1, 0}, 1, false},
+		{TrackSector{1, 0}, TrackSector{1, 20}, 21, false},
+		{TrackSector{1, 20}, TrackSector{2, 1}, 3, false},
+		{TrackSector{1, 0}, TrackSector{35, 16}, 683, false},
+		{TrackSector{2, 0}, TrackSector{1, 0}, 0, true},
+		{TrackSector{1, 21}, TrackSector{2, 0}, 0, true},
+		{TrackSector{35, 0}, TrackSector{36, 0}, 0, true},
+	}
+	for _, c := range cases {
+		got, err := SectorRange(c.first, c.last)
+		if c.fail {
+			if err == nil {
+				t.Errorf("SectorRange(%v, %v) should have failed", c.first, c.last)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SectorRange(%v, %v) failed: %v", c.first, c.last, err)
+		}
+		if len(got) != c.want {
+			t.Errorf("SectorRange(%v, %v) got %d sectors want %d", c.first, c.last, len(got), c.want)
+		}
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	d := NewDisk("raw", "01 2a", DefaultSectorInterleave)
+	sectors, err := SectorRange(TrackSector{30, 0}, TrackSector{31, 3})
+	if err != nil {
+		t.Fatalf("SectorRange failed: %v", err)
+	}
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 1000)
+	if err = d.WriteRaw(sectors, data); err != nil {
+		t.Fatalf("d.WriteRaw failed: %v", err)
+	}
+	for _, ts := range sectors {
+		if !d.bam[ts.Track-1][ts.Sector] {
+			t.Errorf("raw track %d sector %d not allocated", ts.Track, ts.Sector)
+		}
+	}
+	if len(d.Directory()) != 0 {
+		t.Errorf("d.WriteRaw added a directory entry")
+	}
+	got, err := d.ReadRaw(sectors)
+	if err != nil {
+		t.Fatalf("d.ReadRaw failed: %v", err)
+	}
+	if !bytes.Equal(got[:len(data)], data) {
+		t.Errorf("d.ReadRaw content mismatch")
+	}
+	if len(got) != len(sectors)*SectorSize {
+		t.Errorf("d.ReadRaw length got %d want %d", len(got), len(sectors)*SectorSize)
+	}
+
+	if err = d.WriteRaw(sectors[:1], []byte{1}); err == nil {
+		t.Errorf("d.WriteRaw on allocated sector should have failed")
+	}
+	if err = d.WriteRaw([]TrackSector{{DirTrack, 5}}, []byte{1}); err == nil {
+		t.Errorf("d.WriteRaw on DirTrack should have failed")
+	}
+	if err = d.WriteRaw([]TrackSector{{1, 0}}, make([]byte, SectorSize+1)); err == nil {
+		t.Errorf("d.WriteRaw with too much data should have failed")
+	}
+
+	if err = d.Validate(); err != nil {
+		t.Fatalf("d.Validate failed: %v", err)
+	}
+	for _, ts := range sectors {
+		if !d.bam[ts.Track-1][ts.Sector] {
+			t.Errorf("raw track %d sector %d freed by d.Validate", ts.Track, ts.Sector)
+		}
+	}
+	prg := bytes.Repeat([]byte{0xea}, 600*BlockSize)
+	if err = d.AddPrg("normal", prg); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	if got, _ = d.ReadRaw(sectors); !bytes.Equal(got[:len(data)], data) {
+		t.Errorf("raw data was overwritten by d.AddPrg")
+	}
+}
+
+func TestReadWriteSector(t *testing.T) {
+	d := NewDisk("sector", "01 2a", DefaultSectorInterleave)
+	data := make([]byte, SectorSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := d.WriteSector(1, 20, data); err != nil {
+		t.Fatalf("d.WriteSector failed: %v", err)
+	}
+	got, err := d.ReadSector(1, 20)
+	if err != nil {
+		t.Fatalf("d.ReadSector failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("d.ReadSector returned different data than written")
+	}
+	got[0] = 0xff
+	if d.Tracks[0].Sectors[20].Data[0] == 0xff {
+		t.Errorf("d.ReadSector did not return a copy")
+	}
+
+	var ise *IllegalSectorError
+	if _, err = d.ReadSector(1, 21); !errors.As(err, &ise) {
+		t.Errorf("d.ReadSector(1, 21) returned %v, want *IllegalSectorError", err)
+	}
+	if err = d.WriteSector(36, 0, data); !errors.As(err, &ise) {
+		t.Errorf("d.WriteSector(36, 0) returned %v, want *IllegalSectorError", err)
+	}
+	if err = d.WriteSector(1, 0, data[:10]); err == nil {
+		t.Errorf("d.WriteSector of a short sector should have failed")
+	}
+
+	bam, err := d.ReadSector(DirTrack, 0)
+	if err != nil {
+		t.Fatalf("d.ReadSector failed: %v", err)
+	}
+	copy(bam[0x90:], "PATCHED\xa0\xa0\xa0\xa0\xa0\xa0\xa0\xa0\xa0")
+	if err = d.WriteSector(DirTrack, 0, bam); err != nil {
+		t.Fatalf("d.WriteSector failed: %v", err)
+	}
+	if d.Label != "patched" {
+		t.Errorf("Label after writing the BAM sector is %q, want %q", d.Label, "patched")
+	}
+}
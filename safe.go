@@ -0,0 +1,120 @@
+package d64
+
+import (
+	"io"
+	"sync"
+)
+
+// A SafeDisk wraps a Disk for concurrent use by multiple goroutines.
+//
+// A Disk itself is not safe for concurrent use. Its read-only methods, which may run concurrently
+// as long as no other method runs, are: BlocksFree, CanonicalBytes, CanonicalHash, Clone, Directory,
+// Dirty, Err, Extract, ExtractBoot, ExtractToPath, FileInterleave, Layout, Plan, PrintBAMTo, ReadRaw,
+// StartAddress, String, WriteFile, WriteFileWithBackups and WriteTo.
+// Warnings and ClearWarnings are always safe for concurrent use, also during Update, as a rollback keeps
+// the collected warnings.
+// All other methods modify the disk and need exclusive access.
+//
+// SafeDisk enforces this contract with a sync.RWMutex: read-only methods share a read lock,
+// modifying methods take the write lock. Use View and Update for anything not covered by a method.
+type SafeDisk struct {
+	mu sync.RWMutex
+	d  *Disk
+}
+
+// NewSafeDisk returns a SafeDisk wrapping d. Do not use d directly afterwards.
+func NewSafeDisk(d *Disk) *SafeDisk {
+	return &SafeDisk{d: d}
+}
+
+// View calls fn with the read lock held, fn must only call read-only methods of d.
+func (s *SafeDisk) View(fn func(d *Disk) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.d)
+}
+
+// Update calls fn with the write lock held, within a transaction of the disk.
+// fn's modifications are rolled back if it returns an error.
+func (s *SafeDisk) Update(fn func(d *Disk) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.Update(fn)
+}
+
+// Directory returns the directory of the disk.
+func (s *SafeDisk) Directory() []DirEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.Directory()
+}
+
+// Extract returns the prg starting on track, sector.
+func (s *SafeDisk) Extract(track, sector byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.Extract(track, sector)
+}
+
+// BlocksFree returns the amount of unallocated blocks, excluding the DirTrack.
+func (s *SafeDisk) BlocksFree() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.BlocksFree()
+}
+
+// String returns a human readable directory.
+func (s *SafeDisk) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.String()
+}
+
+// WriteTo writes the disk to w, implementing the io.WriterTo interface.
+func (s *SafeDisk) WriteTo(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.WriteTo(w)
+}
+
+// WriteFile atomically writes the disk to path.
+func (s *SafeDisk) WriteFile(path string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.WriteFile(path)
+}
+
+// Clone returns a deep copy of the disk, which is not shared with the SafeDisk.
+func (s *SafeDisk) Clone() *Disk {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.d.Clone()
+}
+
+// AddPrg adds a prg to the disk.
+func (s *SafeDisk) AddPrg(filename string, prg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.AddPrg(filename, prg)
+}
+
+// AddPrgWithOptions adds a prg to the disk, stored according to opt.
+func (s *SafeDisk) AddPrgWithOptions(filename string, prg []byte, opt AddOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.AddPrgWithOptions(filename, prg, opt)
+}
+
+// Scratch deletes all files named filename, returning the amount of scratched files.
+func (s *SafeDisk) Scratch(filename string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.Scratch(filename)
+}
+
+// Rename renames all files named oldName to newName.
+func (s *SafeDisk) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.d.Rename(oldName, newName)
+}
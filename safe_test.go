@@ -0,0 +1,129 @@
+package d64
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestSafeDiskConcurrent(t *testing.T) {
+	prg := make([]byte, 3*BlockSize)
+	for i := range prg {
+		prg[i] = byte(i)
+	}
+	s := NewSafeDisk(NewDisk("safe", "01 2a", DefaultSectorInterleave))
+	if err := s.AddPrg("first", prg); err != nil {
+		t.Fatalf("s.AddPrg failed: %v", err)
+	}
+
+	const writers, readers, files = 4, 8, 5
+	errs := make(chan error, writers*files+readers*files)
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				if err := s.AddPrg(fmt.Sprintf("w%d-%d", w, i), prg); err != nil {
+					errs <- fmt.Errorf("s.AddPrg failed: %w", err)
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				for _, e := range s.Directory() {
+					got, err := s.Extract(e.Track, e.Sector)
+					if err != nil {
+						errs <- fmt.Errorf("s.Extract %q failed: %w", e.Filename, err)
+						continue
+					}
+					if !bytes.Equal(got, prg) {
+						errs <- fmt.Errorf("s.Extract %q returned %d bytes, want %d", e.Filename, len(got), len(prg))
+					}
+				}
+				if _, err := s.WriteTo(io.Discard); err != nil {
+					errs <- fmt.Errorf("s.WriteTo failed: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got, want := len(s.Directory()), 1+writers*files; got != want {
+		t.Errorf("directory has %d files, want %d", got, want)
+	}
+}
+
+func TestSafeDiskDevice(t *testing.T) {
+	bin, err := os.ReadFile(testD64)
+	if err != nil {
+		t.Fatalf("os.ReadFile %q failed: %v", testD64, err)
+	}
+	d, err := OpenDevice(bytes.NewReader(bin))
+	if err != nil {
+		t.Fatalf("OpenDevice failed: %v", err)
+	}
+	s := NewSafeDisk(d)
+	wg := sync.WaitGroup{}
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.View(func(d *Disk) error {
+				for _, e := range d.Directory() {
+					if _, err := d.Extract(e.Track, e.Sector); err != nil {
+						return fmt.Errorf("d.Extract %q failed: %w", e.Filename, err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err = d.Err(); err != nil {
+		t.Errorf("d.Err: %v", err)
+	}
+}
+
+func TestSafeDiskWarnings(t *testing.T) {
+	d := NewDisk("safe", "01 2a", DefaultSectorInterleave)
+	if err := d.AddPrg("one", make([]byte, 3*BlockSize)); err != nil {
+		t.Fatalf("d.AddPrg failed: %v", err)
+	}
+	d.sector(DirTrack, 1).SetTrackLink(MaxTracks + 1)
+	s := NewSafeDisk(d)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			d.Warnings()
+			d.ClearWarnings()
+		}
+	}()
+	fail := errors.New("fail")
+	for i := 0; i < 100; i++ {
+		err := s.Update(func(d *Disk) error {
+			d.Directory()
+			return fail
+		})
+		if err != fail {
+			t.Fatalf("s.Update returned %v, want %v", err, fail)
+		}
+	}
+	<-done
+}
@@ -0,0 +1,138 @@
+package d64
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A File is a named prg and the AddOptions used to store it.
+type File struct {
+	Name    string
+	Prg     []byte
+	Options AddOptions
+	// Side pins the file to a disk side, starting at 1. Zero lets SplitSides pick the side.
+	Side int
+}
+
+// SplitSides distributes files over as many new disk sides as needed, keeping the order of the files.
+// Each file is stored with its AddOptions, a file that does not fit on the current side, e.g. because its
+// track range is full, moves on to the next side. Files pinned to a side are always stored on that side,
+// their blocks, calculated with SizeToBlocks, are kept free for them.
+// Pinning files to a side that would leave an earlier side empty returns an error.
+// Each side gets label and a DiskID containing its side number, e.g. "02 2a" for side 2.
+func SplitSides(label string, interleave byte, files []File) ([]*Disk, error) {
+	pending, err := pinnedUsage(files)
+	if err != nil {
+		return nil, err
+	}
+	disks := []*Disk{}
+	side := func(n int) *Disk {
+		for len(disks) < n {
+			disks = append(disks, NewDisk(label, fmt.Sprintf("%02d 2a", (len(disks)+1)%100), interleave))
+		}
+		return disks[n-1]
+	}
+
+	current := 1
+	for _, f := range files {
+		if f.Side != 0 {
+			pending[f.Side].blocks -= SizeToBlocks(len(f.Prg))
+			pending[f.Side].entries--
+			if err = side(f.Side).AddPrgWithOptions(f.Name, f.Prg, f.Options); err != nil {
+				return disks, fmt.Errorf("d.AddPrgWithOptions %q on side %d failed: %w", f.Name, f.Side, err)
+			}
+			continue
+		}
+		for {
+			d := side(current)
+			fresh := len(d.Directory()) == 0 && pending[current] == nil
+			if pending[current].fits(d, SizeToBlocks(len(f.Prg))) {
+				err = d.AddPrgWithOptions(f.Name, f.Prg, f.Options)
+				if err == nil {
+					break
+				}
+			} else {
+				err = ErrDiskFull
+			}
+			if fresh {
+				return disks, fmt.Errorf("file %q does not fit on a single side: %w", f.Name, err)
+			}
+			current++
+		}
+	}
+
+	for n, d := range disks {
+		if len(d.Directory()) == 0 {
+			return disks, fmt.Errorf("side %d has no files, pin files to consecutive sides", n+1)
+		}
+	}
+	return disks, nil
+}
+
+// sideUsage is the amount of blocks and directory entries used on a side.
+type sideUsage struct{ blocks, entries int }
+
+// fits returns true if a file of blocks fits on d, keeping u free. A nil u keeps nothing free.
+func (u *sideUsage) fits(d *Disk, blocks int) bool {
+	need := sideUsage{blocks: blocks, entries: 1}
+	if u != nil {
+		need.blocks += u.blocks
+		need.entries += u.entries
+	}
+	return need.blocks <= d.BlocksFree() && len(d.Directory())+need.entries <= MaxDirEntries
+}
+
+// pinnedUsage validates the options of files and returns the usage of the files pinned to each side.
+func pinnedUsage(files []File) (map[int]*sideUsage, error) {
+	pinned := map[int]*sideUsage{}
+	for _, f := range files {
+		if err := f.Options.validate(); err != nil {
+			return nil, fmt.Errorf("invalid options for %q: %w", f.Name, err)
+		}
+		if f.Side < 0 {
+			return nil, fmt.Errorf("file %q has illegal side %d", f.Name, f.Side)
+		}
+		if f.Side == 0 {
+			continue
+		}
+		if pinned[f.Side] == nil {
+			pinned[f.Side] = &sideUsage{}
+		}
+		pinned[f.Side].blocks += SizeToBlocks(len(f.Prg))
+		pinned[f.Side].entries++
+		if pinned[f.Side].blocks > MaxBlocks || pinned[f.Side].entries > MaxDirEntries {
+			return nil, fmt.Errorf("files pinned to side %d do not fit on a single side", f.Side)
+		}
+	}
+	return pinned, nil
+}
+
+// WriteSides writes all disks as base_side1.d64, base_side2.d64 etc. including a VICE flip list base.vfl and playlist base.m3u.
+// Returns the paths of all written files.
+func WriteSides(base string, disks []*Disk) (paths []string, err error) {
+	var names []string
+	for n, d := range disks {
+		path := fmt.Sprintf("%s_side%d.d64", base, n+1)
+		if err = d.WriteFile(path); err != nil {
+			return paths, fmt.Errorf("d.WriteFile %q failed: %w", path, err)
+		}
+		paths = append(paths, path)
+		names = append(names, filepath.Base(path))
+	}
+
+	lists := []struct {
+		path, content string
+	}{
+		{base + ".vfl", "# Vice fliplist file\n\nUNIT 8\n" + strings.Join(names, "\n") + "\n"},
+		{base + ".m3u", strings.Join(names, "\n") + "\n"},
+	}
+	for _, l := range lists {
+		if err = os.WriteFile(l.path, []byte(l.content), 0644); err != nil {
+			return paths, fmt.Errorf("os.WriteFile %q failed: %w", l.path, err)
+		}
+		paths = append(paths, l.path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,122 @@
+package d64
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSplitSides(t *testing.T) {
+	big := bytes.Repeat([]byte{0xea}, 300*BlockSize)
+	small := bytes.Repeat([]byte{0x60}, 10*BlockSize)
+	files := []File{
+		{Name: "part1", Prg: big},
+		{Name: "part2", Prg: big},
+		{Name: "part3", Prg: big},
+		{Name: "note", Prg: small, Side: 1},
+		{Name: "part4", Prg: small},
+	}
+	disks, err := SplitSides("split", DefaultSectorInterleave, files)
+	if err != nil {
+		t.Fatalf("SplitSides failed: %v", err)
+	}
+	if len(disks) != 2 {
+		t.Fatalf("SplitSides returned %d sides, want %d", len(disks), 2)
+	}
+	want := [][]string{{"part1", "part2", "note"}, {"part3", "part4"}}
+	for n, d := range disks {
+		var got []string
+		for _, e := range d.Directory() {
+			got = append(got, e.Filename)
+		}
+		if strings.Join(got, ",") != strings.Join(want[n], ",") {
+			t.Errorf("side %d contains %v, want %v", n+1, got, want[n])
+		}
+		if wantID := "0" + strconv.Itoa(n+1) + " 2a"; d.DiskID != wantID {
+			t.Errorf("side %d DiskID got %q want %q", n+1, d.DiskID, wantID)
+		}
+	}
+
+	if _, err = SplitSides("split", DefaultSectorInterleave, []File{{Name: "huge", Prg: make([]byte, 700*BlockSize)}}); err == nil {
+		t.Errorf("SplitSides with a file larger than a side should have failed")
+	}
+	pinned := []File{{Name: "a", Prg: big, Side: 2}, {Name: "b", Prg: big, Side: 2}, {Name: "c", Prg: big, Side: 2}}
+	if _, err = SplitSides("split", DefaultSectorInterleave, pinned); err == nil {
+		t.Errorf("SplitSides with too many files pinned to a side should have failed")
+	}
+}
+
+func TestSplitSidesOptions(t *testing.T) {
+	prg := bytes.Repeat([]byte{0xea}, 30*BlockSize)
+	lowTracks := AddOptions{FirstTrack: 1, LastTrack: 2}
+	files := []File{
+		{Name: "one", Prg: prg, Options: lowTracks},
+		{Name: "two", Prg: prg, Options: lowTracks},
+	}
+	disks, err := SplitSides("split", DefaultSectorInterleave, files)
+	if err != nil {
+		t.Fatalf("SplitSides failed: %v", err)
+	}
+	if len(disks) != 2 {
+		t.Fatalf("SplitSides returned %d sides, want %d", len(disks), 2)
+	}
+	for n, d := range disks {
+		if e := d.Directory(); len(e) != 1 || e[0].Filename != files[n].Name || e[0].Track > 2 {
+			t.Errorf("side %d contains %v, want %q on track 1-2", n+1, e, files[n].Name)
+		}
+	}
+
+	pinned := []File{{Name: "a", Prg: prg, Options: AddOptions{Track: 17, Sector: 0}}, {Name: "b", Prg: prg, Options: AddOptions{Track: 17, Sector: 0}}}
+	if disks, err = SplitSides("split", DefaultSectorInterleave, pinned); err != nil || len(disks) != 2 {
+		t.Errorf("SplitSides with files pinned to the same sector returned %d sides and %v, want 2 sides", len(disks), err)
+	}
+	if _, err = SplitSides("split", DefaultSectorInterleave, []File{{Name: "a", Prg: prg, Options: AddOptions{FirstTrack: 20, LastTrack: 10}}}); err == nil {
+		t.Errorf("SplitSides with an illegal track range should have failed")
+	}
+	if _, err = SplitSides("split", DefaultSectorInterleave, []File{{Name: "a", Prg: prg}, {Name: "b", Prg: prg, Side: 3}}); err == nil {
+		t.Errorf("SplitSides leaving side 2 empty should have failed")
+	}
+}
+
+func TestWriteSides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testwritesides")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	disks := []*Disk{NewDisk("a", "01 2a", DefaultSectorInterleave), NewDisk("b", "02 2a", DefaultSectorInterleave)}
+	base := filepath.Join(dir, "demo")
+	paths, err := WriteSides(base, disks)
+	if err != nil {
+		t.Fatalf("WriteSides failed: %v", err)
+	}
+	if len(paths) != 4 {
+		t.Errorf("WriteSides wrote %d files, want %d", len(paths), 4)
+	}
+	d, err := LoadDisk(base + "_side2.d64")
+	if err != nil {
+		t.Fatalf("LoadDisk failed: %v", err)
+	}
+	if d.Label != "b" {
+		t.Errorf("side 2 label got %q want %q", d.Label, "b")
+	}
+	m3u, err := ioutil.ReadFile(base + ".m3u")
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %v", err)
+	}
+	if string(m3u) != "demo_side1.d64\ndemo_side2.d64\n" {
+		t.Errorf("unexpected m3u content: %q", m3u)
+	}
+	vfl, err := ioutil.ReadFile(base + ".vfl")
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile failed: %v", err)
+	}
+	if !strings.Contains(string(vfl), "UNIT 8\ndemo_side1.d64\ndemo_side2.d64\n") {
+		t.Errorf("unexpected vfl content: %q", vfl)
+	}
+}
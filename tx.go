@@ -0,0 +1,105 @@
+package d64
+
+import "fmt"
+
+// A Tx is a transaction on a Disk, started by Disk.Begin.
+// All modifications of the disk after Begin are undone by Rollback, until Commit is called.
+// Transactions may be nested, a Rollback restores the disk to the state of its own Begin.
+type Tx struct {
+	d        *Disk
+	snapshot *Disk
+	done     bool
+}
+
+// Begin starts a transaction on the disk.
+func (d *Disk) Begin() *Tx {
+	return &Tx{d: d, snapshot: d.snapshot()}
+}
+
+// Commit ends the transaction, keeping all modifications.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	tx.done, tx.snapshot = true, nil
+	return nil
+}
+
+// Rollback ends the transaction, restoring the disk to the state it had when the transaction began.
+// Settings and collected warnings are kept.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	tx.d.restore(tx.snapshot)
+	tx.done, tx.snapshot = true, nil
+	return nil
+}
+
+// Update calls fn within a transaction, fn's modifications are rolled back if it returns an error or panics.
+// This makes batch operations all-or-nothing.
+func (d *Disk) Update(fn func(d *Disk) error) error {
+	tx := d.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			_ = tx.Rollback()
+			panic(r)
+		}
+	}()
+	if err := fn(d); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := d.Err(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Clone returns a deep copy of the disk.
+// A disk opened by OpenDevice is fully read first, the copy is detached from the device.
+func (d *Disk) Clone() *Disk {
+	_ = d.LoadAll()
+	c := d.snapshot()
+	c.dev = nil
+	return c
+}
+
+// snapshot returns a deep copy of the disk, including the state of its device, without reading from the device.
+func (d *Disk) snapshot() *Disk {
+	c := &Disk{
+		Label:            d.Label,
+		DiskID:           d.DiskID,
+		SectorInterleave: d.SectorInterleave,
+		UseDirTrack:      d.UseDirTrack,
+		DirReserve:       d.DirReserve,
+		Allocation:       d.Allocation,
+		WarningHandler:   d.WarningHandler,
+		bam:              d.bam,
+		raw:              d.raw,
+	}
+	c.setData(append([]byte(nil), d.data...))
+	for _, w := range d.Warnings() {
+		c.collect(w)
+	}
+	if d.dev != nil {
+		d.dev.mu.Lock()
+		c.dev = &device{loaded: d.dev.loaded, err: d.dev.err}
+		d.dev.mu.Unlock()
+	}
+	return c
+}
+
+// restore restores the image state from snapshot, created by snapshot: all sectors, the BAM and
+// the Label and DiskID read from it. Settings, warnings and the device itself are left alone.
+func (d *Disk) restore(snapshot *Disk) {
+	copy(d.data, snapshot.data)
+	d.bam, d.raw = snapshot.bam, snapshot.raw
+	d.Label, d.DiskID = snapshot.Label, snapshot.DiskID
+	if d.dev != nil {
+		d.dev.mu.Lock()
+		d.dev.loaded, d.dev.err = snapshot.dev.loaded, snapshot.dev.err
+		d.dev.mu.Unlock()
+	}
+}
@@ -0,0 +1,164 @@
+package d64
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClone(t *testing.T) {
+	d, err := LoadDisk(testD64)
+	if err != nil {
+		t.Fatalf("LoadDisk %q error: %v", testD64, err)
+	}
+	c := d.Clone()
+	if c.CanonicalHash() != d.CanonicalHash() {
+		t.Errorf("clone differs from original")
+	}
+	c.Tracks[0].Sectors[0].Data[0] = 0xff
+	c.bam[0][0] = false
+	if d.Tracks[0].Sectors[0].Data[0] == 0xff || !d.bam[0][0] {
+		t.Errorf("modifying the clone modified the original")
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	d := NewDisk("tx", "01 2a", DefaultSectorInterleave)
+	before := d.CanonicalHash()
+
+	tx := d.Begin()
+	if err := d.AddFile(testPrg1, "one"); err != nil {
+		t.Fatalf("d.AddFile failed: %v", err)
+	}
+	d.Label = "changed"
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("tx.Rollback failed: %v", err)
+	}
+	if d.CanonicalHash() != before || len(d.Directory()) != 0 || d.Label != "tx" {
+		t.Errorf("tx.Rollback did not restore the disk")
+	}
+	if err := tx.Commit(); err == nil {
+		t.Errorf("tx.Commit after tx.Rollback should have failed")
+	}
+
+	tx = d.Begin()
+	if err := d.AddFile(testPrg1, "one"); err != nil {
+		t.Fatalf("d.AddFile failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit failed: %v", err)
+	}
+	if len(d.Directory()) != 1 {
+		t.Errorf("tx.Commit did not keep the added file")
+	}
+
+	fail := errors.New("fail")
+	after := d.CanonicalHash()
+	err := d.Update(func(d *Disk) error {
+		if err := d.AddFile(testPrg2, "two"); err != nil {
+			return err
+		}
+		if _, err := d.Scratch("one"); err != nil {
+			return err
+		}
+		return fail
+	})
+	if err != fail {
+		t.Errorf("d.Update returned %v want %v", err, fail)
+	}
+	if d.CanonicalHash() != after {
+		t.Errorf("failed d.Update did not roll back")
+	}
+}
+
+func TestScratch(t *testing.T) {
+	d := NewDisk("scratch", "01 2a", DefaultSectorInterleave)
+	free := d.BlocksFree()
+	for _, name := range []string{"one", "two", "one"} {
+		if err := d.AddFile(testPrg1, name); err != nil {
+			t.Fatalf("d.AddFile failed: %v", err)
+		}
+	}
+	n, err := d.Scratch("ONE")
+	if err != nil {
+		t.Fatalf("d.Scratch failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("d.Scratch scratched %d files, want %d", n, 2)
+	}
+	dir := d.Directory()
+	if len(dir) != 1 || dir[0].Filename != "two" {
+		t.Errorf("unexpected directory after d.Scratch: %v", dir)
+	}
+	if _, err = d.Scratch("two"); err != nil {
+		t.Fatalf("d.Scratch failed: %v", err)
+	}
+	if d.BlocksFree() != free {
+		t.Errorf("d.BlocksFree got %d want %d", d.BlocksFree(), free)
+	}
+	if _, err = d.Scratch("two"); err == nil {
+		t.Errorf("d.Scratch of non-existing file should have failed")
+	}
+	if err = d.AddFile(testPrg2, "reuse"); err != nil {
+		t.Fatalf("d.AddFile failed: %v", err)
+	}
+	if e := d.Directory()[0]; e.Track != 1 || e.Sector != 0 {
+		t.Errorf("file not stored on freed sectors, got track %d sector %d", e.Track, e.Sector)
+	}
+
+	free = d.BlocksFree()
+	d.Tracks[0].Sectors[0].SetTrackLink(99)
+	if _, err = d.Scratch("reuse"); err == nil {
+		t.Errorf("d.Scratch of file with a broken chain should have failed")
+	}
+	if len(d.Directory()) != 1 || d.BlocksFree() != free {
+		t.Errorf("failed d.Scratch modified the disk")
+	}
+}
+
+func TestRename(t *testing.T) {
+	d := NewDisk("rename", "01 2a", DefaultSectorInterleave)
+	for _, name := range []string{"one", "two"} {
+		if err := d.AddFile(testPrg1, name); err != nil {
+			t.Fatalf("d.AddFile failed: %v", err)
+		}
+	}
+	if err := d.Rename("one", "three"); err != nil {
+		t.Fatalf("d.Rename failed: %v", err)
+	}
+	if got := d.Directory()[0].Filename; got != "three" {
+		t.Errorf("renamed file got %q want %q", got, "three")
+	}
+	if err := d.Rename("three", "two"); err == nil {
+		t.Errorf("d.Rename to existing file should have failed")
+	}
+	if err := d.Rename("two", "TWO"); err != nil {
+		t.Errorf("d.Rename to its own name failed: %v", err)
+	}
+	if err := d.Rename("four", "four"); err == nil {
+		t.Errorf("d.Rename of non-existing file to its own name should have failed")
+	}
+	if err := d.Rename("four", "five"); err == nil {
+		t.Errorf("d.Rename of non-existing file should have failed")
+	}
+}
+
+func TestUpdatePanic(t *testing.T) {
+	d := NewDisk("tx", "01 2a", DefaultSectorInterleave)
+	before := d.CanonicalHash()
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("d.Update did not pass on the panic")
+			}
+		}()
+		_ = d.Update(func(d *Disk) error {
+			if err := d.AddFile(testPrg1, "one"); err != nil {
+				return err
+			}
+			panic("fail")
+		})
+	}()
+	if d.CanonicalHash() != before || len(d.Directory()) != 0 {
+		t.Errorf("panicking d.Update did not roll back")
+	}
+}